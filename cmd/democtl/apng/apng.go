@@ -0,0 +1,100 @@
+package apng
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/renderer"
+	"github.com/wzshiming/democtl/pkg/renderer/video"
+	"github.com/wzshiming/democtl/pkg/styles"
+)
+
+func NewCommand() *cobra.Command {
+	var (
+		input   string
+		output  string
+		profile string
+		fps     = 60
+		workers = runtime.NumCPU()
+	)
+	cmd := &cobra.Command{
+		Use:   "apng",
+		Short: "Convert terminal session to animated png",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if input == "" {
+				return fmt.Errorf("no input file specified")
+			}
+			err := Run(cmd.Context(), input, output, profile, fps, workers)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", input, "input filename")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "output filename")
+	cmd.Flags().StringVarP(&profile, "profile", "p", profile, "profile")
+	cmd.Flags().IntVar(&fps, "fps", fps, "maximum frames per second")
+	cmd.Flags().IntVar(&workers, "workers", workers, "number of frames drawn at once")
+	return cmd
+}
+
+// Run renders the cast file to an animated png, the output defaults to the input with the .png extension.
+func Run(ctx context.Context, inputPath, outputPath, profile string, fps, workers int) (err error) {
+	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	header, err := cast.PeekHeader(input)
+	if err != nil {
+		return err
+	}
+
+	c, err := styles.Resolve(profile, header)
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" {
+		inputExt := filepath.Ext(inputPath)
+		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".png"
+	}
+
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	// A failed or canceled render leaves a truncated file, which is removed.
+	defer func() {
+		closeErr := outputFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}()
+
+	canvas := video.NewCanvas(video.NewAPNGEncoder(outputFile),
+		video.WithGetColor(c.GetColorForHex),
+		video.WithWindows(!c.NoWindows),
+		video.WithTitle(header.Title),
+	)
+
+	err = renderer.Render(ctx, canvas, input,
+		renderer.WithFPS(fps),
+		renderer.WithWorkers(workers),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/cmd/democtl/apng"
+	"github.com/wzshiming/democtl/cmd/democtl/gif"
+	"github.com/wzshiming/democtl/cmd/democtl/mp4"
+	"github.com/wzshiming/democtl/cmd/democtl/svg"
+	"github.com/wzshiming/democtl/cmd/democtl/webm"
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/player"
+	"github.com/wzshiming/democtl/pkg/script"
+	"github.com/wzshiming/democtl/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// formats render a cast file with the options of the manifest, by the extension of their output.
+var formats = map[string]struct {
+	ext    string
+	render func(ctx context.Context, m manifest, input, output string) error
+}{
+	"svg": {".svg", func(ctx context.Context, m manifest, input, output string) error {
+		return svg.Run(ctx, input, output, m.Profile, m.Count, m.FPS)
+	}},
+	"gif": {".gif", func(ctx context.Context, m manifest, input, output string) error {
+		return gif.Run(ctx, input, output, m.Profile, m.FPS, m.FrameWorkers)
+	}},
+	"apng": {".png", func(ctx context.Context, m manifest, input, output string) error {
+		return apng.Run(ctx, input, output, m.Profile, m.FPS, m.FrameWorkers)
+	}},
+	"mp4": {".mp4", func(ctx context.Context, m manifest, input, output string) error {
+		return mp4.Run(ctx, input, output, m.Profile, m.FPS, m.FrameWorkers, m.FFmpegArgs)
+	}},
+	"webm": {".webm", func(ctx context.Context, m manifest, input, output string) error {
+		return webm.Run(ctx, input, output, m.Profile, m.FPS, m.FrameWorkers, m.FFmpegArgs)
+	}},
+}
+
+// manifest lists the demos of a project and how to build them,
+// the paths are relative to the manifest.
+type manifest struct {
+	// Demos are the globs of the .demo files.
+	Demos    []string          `yaml:"demos"`
+	Formats  []string          `yaml:"formats"`
+	Profile  string            `yaml:"profile"`
+	Shell    string            `yaml:"shell"`
+	Vars     map[string]string `yaml:"vars"`
+	Hermetic bool              `yaml:"hermetic"`
+	Rows     uint16            `yaml:"rows"`
+	Cols     uint16            `yaml:"cols"`
+	// Env are the NAME=value variables of the shell, or NAME to pass it through.
+	Env []string `yaml:"env"`
+
+	// FPS, Count, FrameWorkers and FFmpegArgs are the options of the renders,
+	// see the commands of the formats.
+	FPS          int    `yaml:"fps"`
+	Count        string `yaml:"count"`
+	FrameWorkers int    `yaml:"frame-workers"`
+	FFmpegArgs   string `yaml:"ffmpeg-args"`
+}
+
+func NewCommand() *cobra.Command {
+	var (
+		manifestPath string
+		m            = manifest{
+			Formats: []string{"svg"},
+			Shell:   os.Getenv("SHELL"),
+			Rows:    24,
+			Cols:    86,
+
+			FPS:          60,
+			Count:        "infinite",
+			FrameWorkers: runtime.NumCPU(),
+		}
+		workers = runtime.NumCPU()
+		force   bool
+	)
+	if m.Shell == "" {
+		m.Shell = "sh"
+	}
+	cmd := &cobra.Command{
+		Use:   "build [glob...]",
+		Short: "Record and render the demos that changed",
+		Long: "Record the demo files found by the globs or the manifest, when the script, the profile or democtl changed since the last recording, " +
+			"and render them to the formats",
+		Args: cobra.ArbitraryArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if manifestPath != "" {
+				file, err := readManifest(manifestPath)
+				if err != nil {
+					return err
+				}
+				// The flags take precedence over the manifest.
+				flags := cmd.Flags()
+				if len(file.Formats) != 0 && !flags.Changed("format") {
+					m.Formats = file.Formats
+				}
+				if file.Profile != "" && !flags.Changed("profile") {
+					m.Profile = file.Profile
+				}
+				if file.Shell != "" && !flags.Changed("shell") {
+					m.Shell = file.Shell
+				}
+				if !flags.Changed("hermetic") {
+					m.Hermetic = file.Hermetic
+				}
+				if file.Rows != 0 && !flags.Changed("rows") {
+					m.Rows = file.Rows
+				}
+				if file.Cols != 0 && !flags.Changed("cols") {
+					m.Cols = file.Cols
+				}
+				if file.FPS != 0 && !flags.Changed("fps") {
+					m.FPS = file.FPS
+				}
+				if file.Count != "" && !flags.Changed("count") {
+					m.Count = file.Count
+				}
+				if file.FrameWorkers != 0 && !flags.Changed("frame-workers") {
+					m.FrameWorkers = file.FrameWorkers
+				}
+				if file.FFmpegArgs != "" && !flags.Changed("ffmpeg-args") {
+					m.FFmpegArgs = file.FFmpegArgs
+				}
+				// The later variables take precedence.
+				m.Env = append(file.Env, m.Env...)
+				m.Demos = append(m.Demos, file.Demos...)
+				m.Vars = file.Vars
+			}
+			m.Demos = append(m.Demos, args...)
+			if len(m.Demos) == 0 {
+				return fmt.Errorf("no demo files specified, give globs or a manifest")
+			}
+			if workers < 1 {
+				return fmt.Errorf("workers must be at least 1, got %d", workers)
+			}
+			if m.FPS < 1 {
+				return fmt.Errorf("fps must be at least 1, got %d", m.FPS)
+			}
+			for _, format := range m.Formats {
+				if _, ok := formats[format]; !ok {
+					return fmt.Errorf("unknown format %q", format)
+				}
+			}
+			err := run(cmd.Context(), m, workers, force)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&manifestPath, "manifest", "m", manifestPath, "manifest file listing the demos and how to build them")
+	cmd.Flags().StringSliceVarP(&m.Formats, "format", "f", m.Formats, "formats to render: svg, gif, apng, mp4 or webm")
+	cmd.Flags().StringVarP(&m.Profile, "profile", "p", m.Profile, "profile")
+	cmd.Flags().StringVarP(&m.Shell, "shell", "s", m.Shell, "shell script")
+	cmd.Flags().BoolVar(&m.Hermetic, "hermetic", m.Hermetic, "record in a clean environment, see democtl record --hermetic")
+	cmd.Flags().Uint16VarP(&m.Rows, "rows", "r", m.Rows, "number of rows")
+	cmd.Flags().Uint16VarP(&m.Cols, "cols", "c", m.Cols, "number of columns")
+	cmd.Flags().StringArrayVarP(&m.Env, "env", "e", m.Env, "NAME=value variable of the shell, or NAME to pass it through")
+	cmd.Flags().IntVar(&m.FPS, "fps", m.FPS, "maximum frames per second of the renders")
+	cmd.Flags().StringVar(&m.Count, "count", m.Count, "iteration count of the svg")
+	cmd.Flags().IntVar(&m.FrameWorkers, "frame-workers", m.FrameWorkers, "number of frames drawn at once by each video render")
+	cmd.Flags().StringVar(&m.FFmpegArgs, "ffmpeg-args", m.FFmpegArgs, "extra ffmpeg output arguments of mp4 and webm")
+	cmd.Flags().IntVar(&workers, "workers", workers, "number of demos built at the same time")
+	cmd.Flags().BoolVar(&force, "force", force, "record and render every demo")
+	return cmd
+}
+
+// readManifest reads the manifest, with its paths made relative to the current directory.
+func readManifest(name string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return m, err
+	}
+	err = yaml.Unmarshal(data, &m)
+	if err != nil {
+		return m, fmt.Errorf("%s: %w", name, err)
+	}
+
+	dir := filepath.Dir(name)
+	for i, demo := range m.Demos {
+		if !filepath.IsAbs(demo) {
+			m.Demos[i] = filepath.Join(dir, demo)
+		}
+	}
+	if m.Profile != "" && !filepath.IsAbs(m.Profile) {
+		m.Profile = filepath.Join(dir, m.Profile)
+	}
+	return m, nil
+}
+
+// result is what was done for a demo.
+type result struct {
+	demo     string
+	recorded bool
+	rendered []string
+	err      error
+}
+
+func run(ctx context.Context, m manifest, workers int, force bool) error {
+	var demos []string
+	seen := map[string]bool{}
+	for _, pattern := range m.Demos {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no demo files match %q", pattern)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				demos = append(demos, match)
+			}
+		}
+	}
+	sort.Strings(demos)
+
+	profileHash := ""
+	if m.Profile != "" {
+		data, err := os.ReadFile(m.Profile)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		profileHash = hex.EncodeToString(sum[:])
+	}
+
+	results := make([]result, len(demos))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(demos)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = build(ctx, m, demos[j], profileHash, force)
+			}
+		}()
+	}
+	for i := range demos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var built, upToDate, failed int
+	for _, r := range results {
+		var done []string
+		if r.recorded {
+			done = append(done, "recorded")
+		}
+		if len(r.rendered) != 0 {
+			done = append(done, "rendered "+strings.Join(r.rendered, ", "))
+		}
+		switch {
+		case r.err != nil:
+			failed++
+			done = append(done, "failed: "+r.err.Error())
+		case len(done) == 0:
+			upToDate++
+			done = append(done, "up to date")
+		default:
+			built++
+		}
+		fmt.Fprintf(os.Stdout, "%s: %s\n", r.demo, strings.Join(done, ", "))
+	}
+	fmt.Fprintf(os.Stdout, "%d demos: %d built, %d up to date, %d failed\n", len(results), built, upToDate, failed)
+	if failed != 0 {
+		return fmt.Errorf("%d demos failed", failed)
+	}
+	return ctx.Err()
+}
+
+// build records the demo when it changed, and renders the cast file
+// to the formats whose output is missing or older.
+func build(ctx context.Context, m manifest, demo, profileHash string, force bool) result {
+	r := result{demo: demo}
+	castPath := strings.TrimSuffix(demo, filepath.Ext(demo)) + ".cast"
+
+	s, err := script.ParseFile(demo, script.WithVars(m.Vars), script.WithContext(ctx))
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	if force || stale(castPath, s, m, profileHash) {
+		err = record(ctx, m, s, castPath, profileHash)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		r.recorded = true
+	}
+
+	castInfo, err := os.Stat(castPath)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	for _, format := range m.Formats {
+		output := strings.TrimSuffix(castPath, ".cast") + formats[format].ext
+		info, err := os.Stat(output)
+		if !force && err == nil && !info.ModTime().Before(castInfo.ModTime()) {
+			continue
+		}
+		err = render(ctx, m, format, castPath, output)
+		if err != nil {
+			r.err = fmt.Errorf("%s: %w", format, err)
+			return r
+		}
+		r.rendered = append(r.rendered, format)
+	}
+	return r
+}
+
+// stale reports whether the cast file is missing, or was recorded from another script,
+// profile, version of democtl, terminal size or environment.
+func stale(castPath string, s *script.Script, m manifest, profileHash string) bool {
+	f, err := os.Open(castPath)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	header, err := cast.NewDecoder(f).DecodeHeader()
+	if err != nil || header.Democtl == nil {
+		return true
+	}
+	return header.Democtl.Hash != s.Hash ||
+		header.Democtl.Profile != profileHash ||
+		header.Democtl.Settings != settingsHash(m) ||
+		!utils.IsKnownVersion(header.Democtl.Version) ||
+		header.Democtl.Version != utils.Version() ||
+		header.Democtl.Hermetic != m.Hermetic ||
+		header.Width != int(m.Cols) ||
+		header.Height != int(m.Rows)
+}
+
+// settingsHash returns the sha256 of the shell and the environment settings of the manifest.
+// The directory of the shell and the values of the variables passed through are left out,
+// they may differ on every machine.
+func settingsHash(m manifest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "shell=%q\n", strings.TrimSuffix(filepath.Base(m.Shell), ".exe"))
+	for _, kv := range m.Env {
+		fmt.Fprintf(h, "env=%q\n", kv)
+	}
+	sum := h.Sum(nil)
+	return hex.EncodeToString(sum)
+}
+
+// record records the script into a temporary file, which replaces
+// the cast file once the recording succeeded.
+func record(ctx context.Context, m manifest, s *script.Script, castPath, profileHash string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(castPath), ".democtl-*.cast")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// The directories of the demo and of the project are set as by the Makefile,
+	// they are not recorded as they differ on every machine.
+	options := []player.Option{
+		player.WithProfileHash(profileHash),
+		player.WithSettingsHash(settingsHash(m)),
+		player.WithDebug(io.Discard),
+		player.WithOutputFile(castPath),
+		player.WithLocalEnv("WORK_DIR="+filepath.Dir(s.Name), "ROOT_DIR="+root),
+		player.WithEnv(m.Env...),
+	}
+	if m.Hermetic {
+		options = append(options, player.WithHermetic(""))
+	}
+	p := player.NewPlayer(m.Shell, m.Rows, m.Cols, options...)
+	err = p.Play(ctx, s, tmp, filepath.Dir(s.Name))
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), castPath)
+}
+
+// render renders the cast file to the format.
+func render(ctx context.Context, m manifest, format, input, output string) error {
+	err := formats[format].render(ctx, m, input, output)
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 
 	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/cast"
 	"github.com/wzshiming/democtl/pkg/renderer"
 	"github.com/wzshiming/democtl/pkg/renderer/video"
 	"github.com/wzshiming/democtl/pkg/styles"
@@ -18,6 +19,8 @@ func NewCommand() *cobra.Command {
 		input   string
 		output  string
 		profile string
+		fps     = 60
+		workers = runtime.NumCPU()
 	)
 	cmd := &cobra.Command{
 		Use:   "gif",
@@ -27,7 +30,7 @@ func NewCommand() *cobra.Command {
 			if input == "" {
 				return fmt.Errorf("no input file specified")
 			}
-			err := run(cmd.Context(), input, output, profile)
+			err := Run(cmd.Context(), input, output, profile, fps, workers)
 			if err != nil {
 				return err
 			}
@@ -37,91 +40,61 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&input, "input", "i", input, "input filename")
 	cmd.Flags().StringVarP(&output, "output", "o", output, "output filename")
 	cmd.Flags().StringVarP(&profile, "profile", "p", profile, "profile")
+	cmd.Flags().IntVar(&fps, "fps", fps, "maximum frames per second")
+	cmd.Flags().IntVar(&workers, "workers", workers, "number of frames drawn at once")
 	return cmd
 }
 
-func run(ctx context.Context, inputPath, outputPath, profile string) (err error) {
-	c := styles.Default()
-	if profile != "" {
-		c, err = styles.NewStylesFromFile(profile)
-		if err != nil {
-			return err
-		}
-	}
-
+// Run renders the cast file to a gif, the output defaults to the input with the .gif extension.
+func Run(ctx context.Context, inputPath, outputPath, profile string, fps, workers int) (err error) {
 	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
 
-	if outputPath == "" {
-		inputExt := filepath.Ext(inputPath)
-		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".gif"
-	}
-
-	rawDir := outputPath + ".raw"
-
-	err = os.MkdirAll(rawDir, 0755)
+	header, err := cast.PeekHeader(input)
 	if err != nil {
 		return err
 	}
 
-	canvas := video.NewCanvas(rawDir,
-		video.WithGetColor(c.GetColorForHex),
-		video.WithWindows(!c.NoWindows),
-	)
-
-	err = renderer.Render(ctx, canvas, input)
+	c, err := styles.Resolve(profile, header)
 	if err != nil {
 		return err
 	}
 
-	stat, err := os.Stat(outputPath)
-	if err == nil {
-		if stat.IsDir() {
-			return fmt.Errorf("output directory already exists")
-		} else {
-			os.Remove(outputPath)
-		}
-	}
-
-	ffmpegPath, err := exec.LookPath("ffmpeg")
-	if err != nil {
-		fmt.Printf(`# Next step: run the following command to generate the video
-###############################
-ffmpeg \
-  -f concat \
-  -safe 0 \
-  -i %q \
-  %q
-rm -rf %q
-###############################
-`, filepath.Join(rawDir, "frames.txt"), outputPath, rawDir)
-		return nil
-	}
-
-	args := []string{
-		"-f", "concat",
-		"-safe", "0",
-		"-i", filepath.Join(rawDir, "frames.txt"),
-		outputPath,
-	}
-
-	info, err := exec.Command(ffmpegPath, args...).CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("ffmpeg failed: %w:%s", err, string(info))
+	if outputPath == "" {
+		inputExt := filepath.Ext(inputPath)
+		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".gif"
 	}
 
-	_, err = os.Stat(outputPath)
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
+	// A failed or canceled render leaves a truncated file, which is removed.
+	defer func() {
+		closeErr := outputFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}()
 
-	err = os.RemoveAll(rawDir)
+	canvas := video.NewCanvas(video.NewGIFEncoder(outputFile, video.NewPalette(c.GetColorForHex)),
+		video.WithGetColor(c.GetColorForHex),
+		video.WithWindows(!c.NoWindows),
+		video.WithTitle(header.Title),
+	)
+
+	err = renderer.Render(ctx, canvas, input,
+		renderer.WithFPS(fps),
+		renderer.WithWorkers(workers),
+	)
 	if err != nil {
 		return err
 	}
-
 	return nil
 }
@@ -0,0 +1,184 @@
+package info
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/script"
+	"github.com/wzshiming/democtl/pkg/utils"
+)
+
+func NewCommand() *cobra.Command {
+	var (
+		input string
+		check bool
+		vars  []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the metadata of terminal session",
+		Long:  "Show the metadata of terminal session, and whether the script it was recorded from has changed since",
+		Args:  cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if input == "" {
+				return fmt.Errorf("no input file specified")
+			}
+			m := map[string]string{}
+			for _, v := range vars {
+				name, value, ok := strings.Cut(v, "=")
+				if !ok {
+					return fmt.Errorf("--var expects NAME=value, got %q", v)
+				}
+				m[name] = value
+			}
+			err := run(input, check, m)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", input, "input filename")
+	cmd.Flags().BoolVar(&check, "check", check, "fail when the recording is stale")
+	cmd.Flags().StringArrayVar(&vars, "var", vars, "NAME=value variable the script was recorded with, their values are not recorded")
+	return cmd
+}
+
+func run(inputPath string, check bool, vars map[string]string) error {
+	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	d := cast.NewDecoder(input)
+	header, err := d.DecodeHeader()
+	if err != nil {
+		return err
+	}
+
+	var duration float64
+	var outputs int
+	var markers []cast.Event
+	for {
+		e, err := d.DecodeEvent()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		duration = e.Time
+		switch e.Type {
+		case cast.OutputEvent:
+			outputs++
+		case cast.MarkerEvent:
+			markers = append(markers, e)
+		}
+	}
+
+	w := os.Stdout
+	fmt.Fprintf(w, "Version:  %d\n", header.Version)
+	fmt.Fprintf(w, "Size:     %dx%d\n", header.Width, header.Height)
+	if header.Timestamp != 0 {
+		fmt.Fprintf(w, "Recorded: %s\n", time.Unix(header.Timestamp, 0).Format(time.RFC3339))
+	}
+	if header.Title != "" {
+		fmt.Fprintf(w, "Title:    %s\n", header.Title)
+	}
+	fmt.Fprintf(w, "Duration: %s\n", time.Duration(duration*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(w, "Outputs:  %d\n", outputs)
+	if len(header.Env) != 0 {
+		fmt.Fprintf(w, "Env:\n")
+		for _, name := range sortedKeys(header.Env) {
+			fmt.Fprintf(w, "  %s=%s\n", name, header.Env[name])
+		}
+	}
+	if len(markers) != 0 {
+		fmt.Fprintf(w, "Markers:\n")
+		for _, m := range markers {
+			fmt.Fprintf(w, "  %.3f %s\n", m.Time, m.Data)
+		}
+	}
+
+	stale := ""
+	if m := header.Democtl; m != nil {
+		fmt.Fprintf(w, "Democtl:  %s\n", m.Version)
+		if m.Hermetic {
+			fmt.Fprintf(w, "Hermetic: yes\n")
+		}
+		if m.Source != "" {
+			fmt.Fprintf(w, "Source:   %s\n", m.Source)
+			fmt.Fprintf(w, "Hash:     %s\n", m.Hash)
+			if len(m.Vars) != 0 {
+				fmt.Fprintf(w, "Vars:     %s\n", strings.Join(m.Vars, ", "))
+			}
+			stale = staleness(m, filepath.Dir(inputPath), vars)
+			fmt.Fprintf(w, "Stale:    %s\n", stale)
+		}
+	}
+
+	if check && stale != "no" {
+		if stale == "" {
+			return fmt.Errorf("%s was not recorded from a script", inputPath)
+		}
+		return fmt.Errorf("%s is stale: %s", inputPath, stale)
+	}
+	return nil
+}
+
+// staleness tells whether the script changed since the recording, "no" when it did not.
+// The source of the script is relative to dir, the directory of the cast file,
+// vars are the values of the variables it was recorded with.
+func staleness(m *cast.Democtl, dir string, vars map[string]string) string {
+	// Only the variables the script was recorded with are given to it.
+	recorded := map[string]string{}
+	var missing []string
+	for _, name := range m.Vars {
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, "--var "+name+"=...")
+			continue
+		}
+		recorded[name] = value
+	}
+	if len(missing) != 0 {
+		return fmt.Sprintf("unknown, the values of the variables are not recorded, give %s", strings.Join(missing, " "))
+	}
+	source := filepath.FromSlash(m.Source)
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(dir, source)
+	}
+	s, err := script.ParseFile(source, script.WithVars(recorded))
+	if err != nil {
+		return fmt.Sprintf("unknown, %s", strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	if s.Hash != m.Hash {
+		return "yes, the script changed"
+	}
+	if !utils.IsKnownVersion(m.Version) {
+		return fmt.Sprintf("yes, recorded by an unknown build of democtl %s", m.Version)
+	}
+	if version := utils.Version(); version != m.Version {
+		return fmt.Sprintf("yes, recorded by democtl %s, this is %s", m.Version, version)
+	}
+	return "no"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package lint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/script"
+)
+
+func NewCommand() *cobra.Command {
+	var (
+		vars []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lint [file...]",
+		Short: "Check demo files without recording",
+		Long:  "Check the directives, the includes and the variables of demo files without starting a shell, and report every problem with its file and line",
+		Args:  cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m := map[string]string{}
+			for _, v := range vars {
+				name, value, ok := strings.Cut(v, "=")
+				if !ok {
+					return fmt.Errorf("--var expects NAME=value, got %q", v)
+				}
+				m[name] = value
+			}
+			err := run(cmd.Context(), args, m)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVar(&vars, "var", vars, "NAME=value variable expanded as ${{NAME}} in the input, over the ones set by @set")
+	return cmd
+}
+
+func run(ctx context.Context, inputPaths []string, vars map[string]string) error {
+	problems := 0
+	for _, inputPath := range inputPaths {
+		_, err := script.ParseFile(inputPath, script.WithVars(vars), script.WithContext(ctx))
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var errs script.Errors
+		if errors.As(err, &errs) {
+			problems += len(errs)
+		} else {
+			problems++
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if problems != 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+	return nil
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/cmd/democtl/apng"
+	"github.com/wzshiming/democtl/cmd/democtl/build"
 	"github.com/wzshiming/democtl/cmd/democtl/gif"
+	"github.com/wzshiming/democtl/cmd/democtl/info"
+	"github.com/wzshiming/democtl/cmd/democtl/lint"
 	"github.com/wzshiming/democtl/cmd/democtl/mp4"
 	"github.com/wzshiming/democtl/cmd/democtl/play"
 	"github.com/wzshiming/democtl/cmd/democtl/record"
@@ -24,16 +31,24 @@ func main() {
 
 	cmd.AddCommand(
 		record.NewCommand(),
+		lint.NewCommand(),
+		info.NewCommand(),
+		build.NewCommand(),
 		play.NewCommand(),
 		svg.NewCommand(),
 		mp4.NewCommand(),
 		webm.NewCommand(),
 		gif.NewCommand(),
+		apng.NewCommand(),
 	)
 
-	err := cmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := cmd.ExecuteContext(ctx)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
+		stop()
 		os.Exit(1)
 	}
 }
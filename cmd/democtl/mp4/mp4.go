@@ -6,8 +6,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 
+	"github.com/google/shlex"
 	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/cast"
 	"github.com/wzshiming/democtl/pkg/renderer"
 	"github.com/wzshiming/democtl/pkg/renderer/video"
 	"github.com/wzshiming/democtl/pkg/styles"
@@ -15,9 +18,12 @@ import (
 
 func NewCommand() *cobra.Command {
 	var (
-		input   string
-		output  string
-		profile string
+		input      string
+		output     string
+		profile    string
+		fps        = 60
+		workers    = runtime.NumCPU()
+		ffmpegArgs string
 	)
 	cmd := &cobra.Command{
 		Use:   "mp4",
@@ -27,7 +33,7 @@ func NewCommand() *cobra.Command {
 			if input == "" {
 				return fmt.Errorf("no input file specified")
 			}
-			err := run(cmd.Context(), input, output, profile)
+			err := Run(cmd.Context(), input, output, profile, fps, workers, ffmpegArgs)
 			if err != nil {
 				return err
 			}
@@ -37,95 +43,68 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&input, "input", "i", input, "input filename")
 	cmd.Flags().StringVarP(&output, "output", "o", output, "output filename")
 	cmd.Flags().StringVarP(&profile, "profile", "p", profile, "profile")
+	cmd.Flags().IntVar(&fps, "fps", fps, "maximum frames per second")
+	cmd.Flags().IntVar(&workers, "workers", workers, "number of frames drawn at once")
+	cmd.Flags().StringVar(&ffmpegArgs, "ffmpeg-args", ffmpegArgs, "extra ffmpeg output arguments, e.g. \"-crf 18 -pix_fmt yuv444p\"")
 	return cmd
 }
 
-func run(ctx context.Context, inputPath, outputPath, profile string) (err error) {
-	c := styles.Default()
-	if profile != "" {
-		c, err = styles.NewStylesFromFile(profile)
-		if err != nil {
-			return err
-		}
-	}
-
+// Run renders the cast file to a mp4 with ffmpeg, the output defaults to the input with the .mp4 extension.
+func Run(ctx context.Context, inputPath, outputPath, profile string, fps, workers int, ffmpegArgs string) (err error) {
 	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
 
-	if outputPath == "" {
-		inputExt := filepath.Ext(inputPath)
-		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".mp4"
-	}
-
-	rawDir := outputPath + ".raw"
-
-	err = os.MkdirAll(rawDir, 0755)
+	header, err := cast.PeekHeader(input)
 	if err != nil {
 		return err
 	}
 
-	canvas := video.NewCanvas(rawDir,
-		video.WithGetColor(c.GetColorForHex),
-		video.WithWindows(!c.NoWindows),
-	)
-
-	err = renderer.Render(ctx, canvas, input)
+	c, err := styles.Resolve(profile, header)
 	if err != nil {
 		return err
 	}
 
-	stat, err := os.Stat(outputPath)
-	if err == nil {
-		if stat.IsDir() {
-			return fmt.Errorf("output directory already exists")
-		} else {
-			os.Remove(outputPath)
-		}
+	if outputPath == "" {
+		inputExt := filepath.Ext(inputPath)
+		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".mp4"
 	}
 
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err != nil {
-		fmt.Printf(`# Next step: run the following command to generate the video
-###############################
-ffmpeg \
-  -f concat \
-  -safe 0 \
-  -i %q \
-  -vsync vfr \
-  -pix_fmt yuv420p \
-  %q
-rm -rf %q
-###############################
-`, filepath.Join(rawDir, "frames.txt"), outputPath, rawDir)
-		return nil
+		return fmt.Errorf("ffmpeg is required to encode %s: %w", outputPath, err)
 	}
 
-	args := []string{
-		"-f", "concat",
-		"-safe", "0",
-		"-i", filepath.Join(rawDir, "frames.txt"),
-		"-vsync", "vfr",
-		"-pix_fmt", "yuv420p",
-		outputPath,
-	}
-
-	info, err := exec.Command(ffmpegPath, args...).CombinedOutput()
+	extraArgs, err := shlex.Split(ffmpegArgs)
 	if err != nil {
-		return fmt.Errorf("ffmpeg failed: %w:%s", err, string(info))
+		return fmt.Errorf("invalid ffmpeg args: %w", err)
 	}
+	args := append([]string{
+		"-pix_fmt", "yuv420p",
+	}, extraArgs...)
 
-	_, err = os.Stat(outputPath)
-	if err != nil {
-		return err
-	}
+	// The renderer kills ffmpeg and waits for it if anything fails or is canceled,
+	// then the partial output is removed.
+	defer func() {
+		if err != nil {
+			os.Remove(outputPath)
+		}
+	}()
+
+	canvas := video.NewCanvas(video.NewFFmpegEncoder(ffmpegPath, outputPath, args),
+		video.WithGetColor(c.GetColorForHex),
+		video.WithWindows(!c.NoWindows),
+		video.WithTitle(header.Title),
+	)
 
-	err = os.RemoveAll(rawDir)
+	err = renderer.Render(ctx, canvas, input,
+		renderer.WithFPS(fps),
+		renderer.WithWorkers(workers),
+	)
 	if err != nil {
 		return err
 	}
-
 	return nil
 }
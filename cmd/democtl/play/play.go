@@ -24,7 +24,7 @@ func NewCommand() *cobra.Command {
 			if input == "" {
 				return fmt.Errorf("no input file specified")
 			}
-			err := run(input)
+			err := run(cmd.Context(), input)
 			if err != nil {
 				return err
 			}
@@ -35,13 +35,13 @@ func NewCommand() *cobra.Command {
 	return cmd
 }
 
-func run(inputPath string) error {
+func run(ctx context.Context, inputPath string) error {
 	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
-	err = replay.Replay(context.Background(), input)
+	err = replay.Replay(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -3,20 +3,40 @@ package record
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/google/shlex"
 	"github.com/spf13/cobra"
 	"github.com/wzshiming/democtl/pkg/player"
+	"github.com/wzshiming/democtl/pkg/script"
 )
 
 func NewCommand() *cobra.Command {
 	var (
-		rows   uint16 = 24
-		cols   uint16 = 86
-		input  string
-		output string
-		shell  = os.Getenv("SHELL")
+		rows         uint16 = 24
+		cols         uint16 = 86
+		input        string
+		output       string
+		shell        = os.Getenv("SHELL")
+		castVersion  = 2
+		virtualClock bool
+		maxGap       = time.Second
+		promptRegex  string
+		setPrompt    bool
+		integration  bool
+		command      string
+		vars         []string
+		hermetic     bool
+		rcFile       string
+		env          []string
+		envFile      string
+		title        string
+		promptWait   time.Duration
 	)
 	if shell == "" {
 		shell = "sh"
@@ -25,13 +45,101 @@ func NewCommand() *cobra.Command {
 		Use:     "record",
 		Aliases: []string{"rec"},
 		Short:   "Record terminal session",
+		Long:    "Record terminal session, played from the input file or typed by hand when no input file is specified",
 		Args:    cobra.NoArgs,
 
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if castVersion != 2 && castVersion != 3 {
+				return fmt.Errorf("unsupported cast version %d: expected 2 or 3", castVersion)
+			}
+			options := []player.Option{
+				player.WithCastVersion(castVersion),
+			}
+			if command != "" {
+				if cmd.Flags().Changed("shell") {
+					return fmt.Errorf("--command and --shell can't be used together")
+				}
+				args, err := shlex.Split(command)
+				if err != nil {
+					return err
+				}
+				if len(args) == 0 {
+					return fmt.Errorf("empty command")
+				}
+				shell = args[0]
+				options = append(options, player.WithArgs(args[1:]...))
+			}
+			if title != "" {
+				options = append(options, player.WithTitle(title))
+			}
+			if rcFile != "" && !hermetic {
+				return fmt.Errorf("--rcfile needs --hermetic")
+			}
+			if hermetic {
+				options = append(options, player.WithHermetic(rcFile))
+			}
+			if envFile != "" {
+				fileEnv, err := readEnvFile(envFile)
+				if err != nil {
+					return err
+				}
+				options = append(options, player.WithEnv(fileEnv...))
+			}
+			options = append(options, player.WithEnv(env...))
 			if input == "" {
-				return fmt.Errorf("no input file specified")
+				if output == "" {
+					return fmt.Errorf("no output file specified")
+				}
+				// These flags act on the script, which a session typed by hand does not have.
+				for _, name := range []string{"var", "virtual-clock", "max-gap", "prompt-regex", "prompt-timeout", "set-prompt", "shell-integration"} {
+					if cmd.Flags().Changed(name) {
+						return fmt.Errorf("--%s needs an input file", name)
+					}
+				}
+				// The size of the terminal is used unless it is specified.
+				if !cmd.Flags().Changed("rows") && !cmd.Flags().Changed("cols") {
+					rows, cols = 0, 0
+				}
+				err := runInteractive(cmd.Context(), output, shell, rows, cols, options...)
+				if err != nil {
+					return err
+				}
+				return nil
+			}
+			m := map[string]string{}
+			for _, v := range vars {
+				name, value, ok := strings.Cut(v, "=")
+				if !ok {
+					return fmt.Errorf("--var expects NAME=value, got %q", v)
+				}
+				m[name] = value
 			}
-			err := run(input, output, shell, rows, cols)
+			if virtualClock {
+				options = append(options, player.WithVirtualClock(maxGap))
+			}
+			if promptRegex != "" && setPrompt {
+				return fmt.Errorf("--prompt-regex and --set-prompt can't be used together")
+			}
+			if integration && (promptRegex != "" || setPrompt) {
+				return fmt.Errorf("--shell-integration can't be used with --prompt-regex or --set-prompt")
+			}
+			if promptRegex != "" {
+				re, err := regexp.Compile(promptRegex)
+				if err != nil {
+					return fmt.Errorf("invalid prompt regex: %w", err)
+				}
+				options = append(options, player.WithPrompt(re))
+			}
+			if promptWait != 0 {
+				options = append(options, player.WithPromptTimeout(promptWait))
+			}
+			if setPrompt {
+				options = append(options, player.WithSetPrompt())
+			}
+			if integration {
+				options = append(options, player.WithShellIntegration())
+			}
+			err := run(cmd.Context(), input, output, shell, rows, cols, m, options...)
 			if err != nil {
 				return err
 			}
@@ -43,31 +151,92 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&input, "input", "i", input, "input filename")
 	cmd.Flags().StringVarP(&output, "output", "o", output, "output filename")
 	cmd.Flags().StringVarP(&shell, "shell", "s", shell, "shell script")
+	cmd.Flags().StringVar(&command, "command", command, "program with its arguments to record instead of the shell, such as a REPL")
+	cmd.Flags().IntVar(&castVersion, "cast-version", castVersion, "version of the cast format to write (2 or 3)")
+	cmd.Flags().BoolVar(&virtualClock, "virtual-clock", virtualClock, "time the events by the script instead of the real time, the sleeps do not wait")
+	cmd.Flags().StringVar(&promptRegex, "prompt-regex", promptRegex, "regex of the last line of the shell prompt, instead of guessing it")
+	cmd.Flags().DurationVar(&promptWait, "prompt-timeout", promptWait, "longest time a command may take to give the prompt back, 0 waits forever")
+	cmd.Flags().BoolVar(&setPrompt, "set-prompt", setPrompt, "set a known prompt in the shell before recording")
+	cmd.Flags().BoolVar(&integration, "shell-integration", integration, "inject the shell integration hooks of bash, zsh or fish to know when the commands finish and their exit statuses")
+	cmd.Flags().StringArrayVar(&vars, "var", vars, "NAME=value variable expanded as ${{NAME}} in the input, over the ones set by @set")
+	cmd.Flags().StringVar(&title, "title", title, "title of the recording, over the one set by @title")
+	cmd.Flags().BoolVar(&hermetic, "hermetic", hermetic, "start the shell with a clean environment, a temporary HOME and no rc files, and record the environment")
+	cmd.Flags().StringVar(&rcFile, "rcfile", rcFile, "rc file read by the shell with --hermetic")
+	cmd.Flags().StringArrayVarP(&env, "env", "e", env, "NAME=value variable of the shell, or NAME to pass it through")
+	cmd.Flags().StringVar(&envFile, "env-file", envFile, "file of NAME=value variables of the shell, one per line")
+	cmd.Flags().DurationVar(&maxGap, "max-gap", maxGap, "longest time between two outputs with the virtual clock")
 	return cmd
 }
 
-func run(inputPath, outputPath, shell string, rows, cols uint16) error {
-	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
+func run(ctx context.Context, inputPath, outputPath, shell string, rows, cols uint16, vars map[string]string, options ...player.Option) error {
+	// Every problem of the script is found before recording.
+	s, err := script.ParseFile(inputPath, script.WithVars(vars), script.WithContext(ctx))
 	if err != nil {
 		return err
 	}
-	defer input.Close()
 
 	if outputPath == "" {
 		inputExt := filepath.Ext(inputPath)
 		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".cast"
 	}
 
-	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	options = append(options, player.WithOutputFile(outputPath))
+	p := player.NewPlayer(shell, rows, cols, options...)
+	return writeFile(outputPath, func(w io.Writer) error {
+		return p.Play(ctx, s, w, filepath.Dir(inputPath))
+	})
+}
+
+func runInteractive(ctx context.Context, outputPath, shell string, rows, cols uint16, options ...player.Option) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	p := player.NewPlayer(shell, rows, cols, options...)
+	return writeFile(outputPath, func(w io.Writer) error {
+		return p.Interact(ctx, os.Stdin, w, dir)
+	})
+}
+
+// writeFile writes the recording into a temporary file, which replaces
+// the output file once the recording succeeded, so a failed recording
+// does not leave a partial cast file that looks up to date.
+func writeFile(outputPath string, record func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".democtl-*.cast")
 	if err != nil {
 		return err
 	}
-	defer outputFile.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	p := player.NewPlayer(shell, rows, cols)
-	err = p.Run(context.Background(), input, outputFile, filepath.Dir(inputPath))
+	err = record(tmp)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outputPath)
+}
+
+// readEnvFile reads the NAME=value lines of the file, skipping the empty lines and the comments.
+func readEnvFile(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			return nil, fmt.Errorf("%s:%d: expected NAME=value, got %q", name, i+1, line)
+		}
+		env = append(env, line)
+	}
+	return env, nil
 }
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/wzshiming/democtl/pkg/cast"
 	"github.com/wzshiming/democtl/pkg/renderer"
 	"github.com/wzshiming/democtl/pkg/renderer/svg"
 	"github.com/wzshiming/democtl/pkg/styles"
@@ -18,6 +19,7 @@ func NewCommand() *cobra.Command {
 		output         string
 		profile        string
 		iterationCount string = "infinite"
+		fps                   = 60
 	)
 	cmd := &cobra.Command{
 		Use:   "svg",
@@ -27,7 +29,7 @@ func NewCommand() *cobra.Command {
 			if input == "" {
 				return fmt.Errorf("no input file specified")
 			}
-			err := run(cmd.Context(), input, output, profile, iterationCount)
+			err := Run(cmd.Context(), input, output, profile, iterationCount, fps)
 			if err != nil {
 				return err
 			}
@@ -38,24 +40,28 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&output, "output", "o", output, "output filename")
 	cmd.Flags().StringVarP(&profile, "profile", "p", profile, "profile")
 	cmd.Flags().StringVar(&iterationCount, "count", iterationCount, "iteration count")
+	cmd.Flags().IntVar(&fps, "fps", fps, "maximum frames per second")
 	return cmd
 }
 
-func run(ctx context.Context, inputPath, outputPath, profile string, iterationCount string) (err error) {
-	c := styles.Default()
-	if profile != "" {
-		c, err = styles.NewStylesFromFile(profile)
-		if err != nil {
-			return err
-		}
-	}
-
+// Run renders the cast file to an animated svg, the output defaults to the input with the .svg extension.
+func Run(ctx context.Context, inputPath, outputPath, profile string, iterationCount string, fps int) (err error) {
 	input, err := os.OpenFile(inputPath, os.O_RDONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
 
+	header, err := cast.PeekHeader(input)
+	if err != nil {
+		return err
+	}
+
+	c, err := styles.Resolve(profile, header)
+	if err != nil {
+		return err
+	}
+
 	if outputPath == "" {
 		inputExt := filepath.Ext(inputPath)
 		outputPath = inputPath[:len(inputPath)-len(inputExt)] + ".svg"
@@ -71,9 +77,12 @@ func run(ctx context.Context, inputPath, outputPath, profile string, iterationCo
 		svg.WithIterationCount(iterationCount),
 		svg.WithGetColor(c.GetColorForHex),
 		svg.WithWindows(!c.NoWindows),
+		svg.WithTitle(header.Title),
 	)
 
-	err = renderer.Render(ctx, canvas, input)
+	err = renderer.Render(ctx, canvas, input,
+		renderer.WithFPS(fps),
+	)
 	if err != nil {
 		return err
 	}
@@ -1,12 +1,22 @@
 package cast
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"io"
 )
 
 type Decoder struct {
-	r *json.Decoder
+	r      *json.Decoder
+	source io.Reader
+
+	// lines reads the events of version 3 line by line,
+	// which may contain comments.
+	lines *bufio.Reader
+
+	version  int
+	lastTime float64
 
 	stdout     []Event
 	index      int
@@ -14,7 +24,10 @@ type Decoder struct {
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: json.NewDecoder(r)}
+	return &Decoder{
+		r:      json.NewDecoder(r),
+		source: r,
+	}
 }
 
 func (d *Decoder) DecodeHeader() (Header, error) {
@@ -29,9 +42,37 @@ func (d *Decoder) DecodeHeader() (Header, error) {
 		h.Version = 2
 	}
 
+	if h.Version == 3 {
+		if h.Term != nil {
+			h.Width = h.Term.Cols
+			h.Height = h.Term.Rows
+			if h.Theme == nil {
+				h.Theme = h.Term.Theme
+			}
+		}
+		d.lines = bufio.NewReader(io.MultiReader(d.r.Buffered(), d.source))
+	}
+
+	d.version = h.Version
+	return h, nil
+}
+
+// PeekHeader decodes the header of r and seeks back to the start,
+// so r can be decoded again from the header.
+func PeekHeader(r io.ReadSeeker) (Header, error) {
+	h, err := NewDecoder(r).DecodeHeader()
+	if err != nil {
+		return Header{}, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return Header{}, err
+	}
 	return h, nil
 }
 
+// DecodeEvent returns the next event, the time of the event is always
+// the offset from the start of the recording regardless of the version.
 func (d *Decoder) DecodeEvent() (Event, error) {
 	if d.stdout != nil {
 		if d.index == len(d.stdout) {
@@ -46,9 +87,36 @@ func (d *Decoder) DecodeEvent() (Event, error) {
 		return e, nil
 	}
 
+	if d.lines != nil {
+		return d.decodeEventV3()
+	}
+
 	var e Event
 	if err := d.r.Decode(&e); err != nil {
 		return Event{}, err
 	}
 	return e, nil
 }
+
+func (d *Decoder) decodeEventV3() (Event, error) {
+	for {
+		line, err := d.lines.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return Event{}, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var e Event
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return Event{}, err
+		}
+		e.Time += d.lastTime
+		d.lastTime = e.Time
+		return e, nil
+	}
+}
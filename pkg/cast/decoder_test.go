@@ -0,0 +1,168 @@
+package cast
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodeAll decodes the header and every event of r.
+func decodeAll(t *testing.T, r io.Reader) (Header, []Event) {
+	t.Helper()
+	d := NewDecoder(r)
+	header, err := d.DecodeHeader()
+	if err != nil {
+		t.Fatalf("DecodeHeader() error = %v", err)
+	}
+	var events []Event
+	for {
+		e, err := d.DecodeEvent()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return header, events
+			}
+			t.Fatalf("DecodeEvent() error = %v", err)
+		}
+		events = append(events, e)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		file   string
+		title  string
+		theme  *Theme
+		events []Event
+	}{
+		{
+			file:  "v1.json",
+			title: "v1",
+			events: []Event{
+				{Time: 0.5, Type: OutputEvent, Data: "$ "},
+				{Time: 0.75, Type: OutputEvent, Data: "echo hi\r\n"},
+				{Time: 1.75, Type: OutputEvent, Data: "hi\r\n"},
+			},
+		},
+		{
+			file:  "v2.cast",
+			title: "v2",
+			events: []Event{
+				{Time: 0.5, Type: OutputEvent, Data: "$ "},
+				{Time: 0.75, Type: InputEvent, Data: "echo hi\r"},
+				{Time: 0.75, Type: OutputEvent, Data: "echo hi\r\n"},
+				{Time: 1.75, Type: OutputEvent, Data: "hi\r\n"},
+				{Time: 2, Type: ResizeEvent, Data: "100x30"},
+				{Time: 2.5, Type: MarkerEvent, Data: "done"},
+			},
+		},
+		{
+			file:  "v3.cast",
+			title: "v3",
+			theme: &Theme{
+				Fg:      "#d0d0d0",
+				Bg:      "#212121",
+				Palette: "#000000:#dd3c69:#4ebf22:#ddaf3c:#26b0d7:#b954e1:#54e1b9:#d9d9d9",
+			},
+			events: []Event{
+				{Time: 0.5, Type: OutputEvent, Data: "$ "},
+				{Time: 0.75, Type: InputEvent, Data: "echo hi\r"},
+				{Time: 0.75, Type: OutputEvent, Data: "echo hi\r\n"},
+				{Time: 1.75, Type: OutputEvent, Data: "hi\r\n"},
+				{Time: 2, Type: ResizeEvent, Data: "100x30"},
+				{Time: 2.5, Type: MarkerEvent, Data: "done"},
+				{Time: 2.75, Type: ExitEvent, Data: "0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			header, events := decodeAll(t, f)
+			if header.Width != 80 || header.Height != 24 {
+				t.Errorf("size = %dx%d, want 80x24", header.Width, header.Height)
+			}
+			if header.Title != tt.title {
+				t.Errorf("Title = %q, want %q", header.Title, tt.title)
+			}
+			if !reflect.DeepEqual(header.Theme, tt.theme) {
+				t.Errorf("Theme = %+v, want %+v", header.Theme, tt.theme)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events =\n%+v\nwant\n%+v", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestDecoderVersion3WithoutTrailingNewline(t *testing.T) {
+	input := `{"version": 3, "term": {"cols": 10, "rows": 5}}` + "\n" +
+		`[1, "o", "a"]` + "\n" +
+		`[1, "o", "b"]`
+	header, events := decodeAll(t, strings.NewReader(input))
+	if header.Version != 3 || header.Width != 10 || header.Height != 5 {
+		t.Errorf("header = %+v, want version 3 and size 10x5", header)
+	}
+	want := []Event{
+		{Time: 1, Type: OutputEvent, Data: "a"},
+		{Time: 2, Type: OutputEvent, Data: "b"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+}
+
+func TestDecoderInvalidEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "unknown type", input: `{"version": 2, "width": 10, "height": 5}` + "\n" + `[1, "z", "a"]` + "\n"},
+		{name: "wrong length", input: `{"version": 2, "width": 10, "height": 5}` + "\n" + `[1, "o", "a", "b"]` + "\n"},
+		{name: "version 3 unknown type", input: `{"version": 3, "term": {"cols": 10, "rows": 5}}` + "\n" + `[1, "z", "a"]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tt.input))
+			_, err := d.DecodeHeader()
+			if err != nil {
+				t.Fatalf("DecodeHeader() error = %v", err)
+			}
+			_, err = d.DecodeEvent()
+			if err == nil || errors.Is(err, io.EOF) {
+				t.Errorf("DecodeEvent() error = %v, want an error", err)
+			}
+		})
+	}
+}
+
+func TestPeekHeader(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "v3.cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	header, err := PeekHeader(f)
+	if err != nil {
+		t.Fatalf("PeekHeader() error = %v", err)
+	}
+	if header.Title != "v3" {
+		t.Errorf("Title = %q, want %q", header.Title, "v3")
+	}
+	again, events := decodeAll(t, f)
+	if !reflect.DeepEqual(again, header) {
+		t.Errorf("header decoded again = %+v, want %+v", again, header)
+	}
+	if len(events) != 7 {
+		t.Errorf("decoded %d events, want 7", len(events))
+	}
+}
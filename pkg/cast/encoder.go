@@ -3,23 +3,72 @@ package cast
 import (
 	"encoding/json"
 	"io"
+	"math"
 )
 
 type Encoder struct {
 	w *json.Encoder
+
+	version  int
+	lastTime float64
 }
 
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{
-		w: json.NewEncoder(w),
+type EncoderOption func(*Encoder)
+
+// WithVersion sets the version of the format to write, either 2 or 3.
+func WithVersion(version int) EncoderOption {
+	return func(e *Encoder) {
+		e.version = version
 	}
 }
 
+func NewEncoder(w io.Writer, options ...EncoderOption) *Encoder {
+	e := &Encoder{
+		w:       json.NewEncoder(w),
+		version: 2,
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
 func (e *Encoder) EncodeHeader(h Header) error {
+	if e.version == 3 {
+		term := Term{
+			Cols:  h.Width,
+			Rows:  h.Height,
+			Theme: h.Theme,
+		}
+		if h.Term != nil {
+			term.Type = h.Term.Type
+			term.Version = h.Term.Version
+		}
+		return e.w.Encode(headerV3{
+			Version:       3,
+			Term:          term,
+			Timestamp:     h.Timestamp,
+			IdleTimeLimit: h.IdleTimeLimit,
+			Command:       h.Command,
+			Title:         h.Title,
+			Env:           h.Env,
+			Democtl:       h.Democtl,
+		})
+	}
+
 	h.Version = 2
+	h.Term = nil
 	return e.w.Encode(h)
 }
 
+// EncodeEvent writes the event, the time of the event is the offset
+// from the start of the recording regardless of the version.
+// The relative times of version 3 are rounded from the sum of the written ones,
+// so the rounding errors do not add up.
 func (e *Encoder) EncodeEvent(v Event) error {
+	if e.version == 3 {
+		v.Time = math.Round((v.Time-e.lastTime)*1e6) / 1e6
+		e.lastTime += v.Time
+	}
 	return e.w.Encode(v)
 }
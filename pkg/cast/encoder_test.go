@@ -0,0 +1,133 @@
+package cast
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderRoundTrip(t *testing.T) {
+	header := Header{
+		Width:     80,
+		Height:    24,
+		Timestamp: 1700000000,
+		Title:     "round trip",
+		Env:       map[string]string{"SHELL": "/bin/bash"},
+		Theme: &Theme{
+			Fg:      "#d0d0d0",
+			Bg:      "#212121",
+			Palette: "#000000:#dd3c69:#4ebf22:#ddaf3c:#26b0d7:#b954e1:#54e1b9:#d9d9d9",
+		},
+		Democtl: &Democtl{
+			Source:  "demo.demo",
+			Hash:    "abc",
+			Version: "v1.0.0",
+		},
+	}
+	events := []Event{
+		{Time: 0.1, Type: OutputEvent, Data: "$ "},
+		{Time: 0.2, Type: InputEvent, Data: "e"},
+		{Time: 0.3, Type: OutputEvent, Data: "eé\x1b[0m"},
+		{Time: 1.7, Type: ResizeEvent, Data: "100x30"},
+		{Time: 1.7, Type: MarkerEvent, Data: "marker"},
+		{Time: 2.123456, Type: ExitEvent, Data: "0"},
+	}
+
+	for _, version := range []int{2, 3} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, WithVersion(version))
+		err := e.EncodeHeader(header)
+		if err != nil {
+			t.Fatalf("version %d: EncodeHeader() error = %v", version, err)
+		}
+		for _, event := range events {
+			err = e.EncodeEvent(event)
+			if err != nil {
+				t.Fatalf("version %d: EncodeEvent() error = %v", version, err)
+			}
+		}
+
+		got, gotEvents := decodeAll(t, &buf)
+		if got.Version != version {
+			t.Errorf("version %d: Version = %d", version, got.Version)
+		}
+		if got.Width != header.Width || got.Height != header.Height ||
+			got.Timestamp != header.Timestamp || got.Title != header.Title {
+			t.Errorf("version %d: header = %+v, want %+v", version, got, header)
+		}
+		if !reflect.DeepEqual(got.Env, header.Env) {
+			t.Errorf("version %d: Env = %v, want %v", version, got.Env, header.Env)
+		}
+		if !reflect.DeepEqual(got.Theme, header.Theme) {
+			t.Errorf("version %d: Theme = %+v, want %+v", version, got.Theme, header.Theme)
+		}
+		if !reflect.DeepEqual(got.Democtl, header.Democtl) {
+			t.Errorf("version %d: Democtl = %+v, want %+v", version, got.Democtl, header.Democtl)
+		}
+		if len(gotEvents) != len(events) {
+			t.Fatalf("version %d: decoded %d events, want %d", version, len(gotEvents), len(events))
+		}
+		for i, event := range gotEvents {
+			want := events[i]
+			// The relative times of version 3 are rounded to microseconds.
+			if event.Type != want.Type || event.Data != want.Data || math.Abs(event.Time-want.Time) > 1e-6 {
+				t.Errorf("version %d: event %d = %+v, want %+v", version, i, event, want)
+			}
+		}
+	}
+}
+
+func TestEncoderVersion3Drift(t *testing.T) {
+	// The times do not land on microseconds, the error of each rounding is 0.4µs.
+	const n = 10000
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithVersion(3))
+	err := e.EncodeHeader(Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		err = e.EncodeEvent(Event{Time: float64(i) * 1.0000004e-3, Type: OutputEvent, Data: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, events := decodeAll(t, &buf)
+	if len(events) != n {
+		t.Fatalf("decoded %d events, want %d", len(events), n)
+	}
+	for i, event := range events {
+		want := float64(i+1) * 1.0000004e-3
+		if math.Abs(event.Time-want) > 1e-6 {
+			t.Fatalf("event %d: Time = %v, want %v", i, event.Time, want)
+		}
+	}
+}
+
+func TestEncoderVersion3Layout(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, WithVersion(3))
+	err := e.EncodeHeader(Header{Width: 80, Height: 24, Term: &Term{Type: "xterm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []Event{
+		{Time: 0.5, Type: OutputEvent, Data: "a"},
+		{Time: 1.25, Type: OutputEvent, Data: "b"},
+	} {
+		err = e.EncodeEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `{"version":3,"term":{"cols":80,"rows":24,"type":"xterm"}}
+[0.5,"o","a"]
+[0.75,"o","b"]
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
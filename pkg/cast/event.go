@@ -5,11 +5,40 @@ import (
 	"fmt"
 )
 
+// EventType is the type code of an event.
+type EventType string
+
+const (
+	// OutputEvent is data written to the terminal.
+	OutputEvent EventType = "o"
+	// InputEvent is data read from the keyboard.
+	InputEvent EventType = "i"
+	// ResizeEvent is a change of the terminal size, the data is "COLSxROWS".
+	ResizeEvent EventType = "r"
+	// MarkerEvent is a named point in the recording, the data is the label.
+	MarkerEvent EventType = "m"
+	// ExitEvent is the exit of the recorded process, the data is the status.
+	ExitEvent EventType = "x"
+)
+
 type Event struct {
 	Time float64
+	Type EventType
 	Data string
 }
 
+// Size returns the terminal size carried by a resize event.
+func (e Event) Size() (width, height int, err error) {
+	if e.Type != ResizeEvent {
+		return 0, 0, fmt.Errorf("wrong event type (%s): expected %s", e.Type, ResizeEvent)
+	}
+	_, err = fmt.Sscanf(e.Data, "%dx%d", &width, &height)
+	if err != nil {
+		return 0, 0, fmt.Errorf("wrong resize data (%q): %w", e.Data, err)
+	}
+	return width, height, nil
+}
+
 // UnmarshalJSON reads json list as Event fields.
 func (e *Event) UnmarshalJSON(data []byte) error {
 	var v []json.RawMessage
@@ -28,6 +57,7 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 		if err != nil {
 			return err
 		}
+		e.Type = OutputEvent
 		return nil
 	}
 
@@ -40,8 +70,11 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	if t != "o" {
-		return fmt.Errorf("wrong event type (%s): expected o", t)
+	switch t := EventType(t); t {
+	case OutputEvent, InputEvent, ResizeEvent, MarkerEvent, ExitEvent:
+		e.Type = t
+	default:
+		return fmt.Errorf("wrong event type (%s): expected one of o, i, r, m, x", t)
 	}
 
 	err = json.Unmarshal(v[0], &e.Time)
@@ -59,6 +92,10 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 
 // MarshalJSON reads json list as Event fields.
 func (e Event) MarshalJSON() ([]byte, error) {
-	data := [...]any{e.Time, "o", e.Data}
+	t := e.Type
+	if t == "" {
+		t = OutputEvent
+	}
+	data := [...]any{e.Time, t, e.Data}
 	return json.Marshal(data)
 }
@@ -1,10 +1,71 @@
 package cast
 
 type Header struct {
-	Version   int               `json:"version"`
-	Width     int               `json:"width"`
-	Height    int               `json:"height"`
-	Timestamp int64             `json:"timestamp,omitempty"`
-	Env       map[string]string `json:"env,omitempty"`
-	Stdout    []Event           `json:"stdout,omitempty"`
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	Theme         *Theme            `json:"theme,omitempty"`
+	Stdout        []Event           `json:"stdout,omitempty"`
+
+	// Term is the terminal description of version 3,
+	// the size and theme are also copied to the header when decoding.
+	Term *Term `json:"term,omitempty"`
+
+	// Democtl tells how democtl recorded the cast.
+	Democtl *Democtl `json:"democtl,omitempty"`
+}
+
+// Democtl tells how democtl recorded the cast, to find out whether it is stale.
+type Democtl struct {
+	// Source is the path of the script, relative to the cast file.
+	Source string `json:"source,omitempty"`
+	// Hash is the sha256 of the script, with the included scripts and the variables expanded.
+	Hash string `json:"hash,omitempty"`
+	// Vars are the names of the variables given to the script,
+	// their values are left out as they may be secrets.
+	Vars []string `json:"vars,omitempty"`
+	// Version is the version of democtl.
+	Version string `json:"version,omitempty"`
+	// Profile is the sha256 of the profile the cast is rendered with by democtl build.
+	Profile string `json:"profile,omitempty"`
+	// Hermetic is set when the shell was started with a clean environment.
+	Hermetic bool `json:"hermetic,omitempty"`
+	// Settings is the sha256 of the shell and the environment settings the cast is recorded with by democtl build.
+	Settings string `json:"settings,omitempty"`
+}
+
+// Theme is the color theme of the recorded terminal.
+type Theme struct {
+	// Fg is the default foreground color, e.g. "#d0d0d0".
+	Fg string `json:"fg"`
+	// Bg is the default background color, e.g. "#212121".
+	Bg string `json:"bg"`
+	// Palette is a colon separated list of 8 or 16 colors.
+	Palette string `json:"palette"`
+}
+
+// Term is the terminal description of version 3.
+type Term struct {
+	Cols    int    `json:"cols"`
+	Rows    int    `json:"rows"`
+	Type    string `json:"type,omitempty"`
+	Version string `json:"version,omitempty"`
+	Theme   *Theme `json:"theme,omitempty"`
+}
+
+// headerV3 is the layout of the header of version 3.
+type headerV3 struct {
+	Version       int               `json:"version"`
+	Term          Term              `json:"term"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	Democtl       *Democtl          `json:"democtl,omitempty"`
 }
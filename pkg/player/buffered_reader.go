@@ -83,12 +83,15 @@ func (r *bufferedReader) Run() {
 }
 
 func (r *bufferedReader) ReadWithTimeout(p []byte, timeout time.Duration) (n int, err error) {
+	// Take the notification before checking the length,
+	// so a write right after the check still wakes us up.
+	updated := r.buffer.Updated()
 	if r.buffer.Len() > 0 {
 		return r.buffer.Read(p)
 	}
 
 	select {
-	case _, ok := <-r.buffer.Updated():
+	case _, ok := <-updated:
 		if !ok {
 			return 0, io.EOF
 		}
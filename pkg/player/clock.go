@@ -0,0 +1,58 @@
+package player
+
+import (
+	"time"
+)
+
+// clock gives the times of the recorded events.
+type clock interface {
+	// Now returns the time of an event in microseconds.
+	Now() int64
+	// Sleep lets d pass between the events.
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() int64 {
+	return time.Now().UnixMicro()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// clockResolution is the precision of the virtual clock, the
+// differences of speed between two runs below it are not seen.
+const clockResolution = 10 * time.Millisecond
+
+// virtualClock only moves by the sleeps and by the time between the
+// events, which is rounded and capped, so recordings do not depend on
+// the speed of the machine and the sleeps do not take real time.
+type virtualClock struct {
+	now    time.Duration
+	last   time.Time
+	maxGap time.Duration
+}
+
+func newVirtualClock(maxGap time.Duration) *virtualClock {
+	now := time.Now()
+	return &virtualClock{
+		now:    time.Duration(now.UnixNano()).Truncate(time.Second),
+		last:   now,
+		maxGap: maxGap,
+	}
+}
+
+func (c *virtualClock) Now() int64 {
+	now := time.Now()
+	gap := now.Sub(c.last).Round(clockResolution)
+	c.last = now
+	c.now += min(gap, c.maxGap)
+	return c.now.Microseconds()
+}
+
+func (c *virtualClock) Sleep(d time.Duration) {
+	c.now += d
+	c.last = time.Now()
+}
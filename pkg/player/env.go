@@ -0,0 +1,174 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WithHermetic starts the shell with a clean environment and an empty temporary HOME,
+// without the rc files of the shell, or with rcFile only when it is given.
+// The environment is recorded in the header.
+func WithHermetic(rcFile string) Option {
+	return func(p *Player) {
+		p.hermetic = true
+		p.rcFile = rcFile
+	}
+}
+
+// WithEnv adds the NAME=value variables to the environment of the shell,
+// a NAME alone passes the variable of the current environment through.
+func WithEnv(env ...string) Option {
+	return func(p *Player) {
+		p.env = append(p.env, env...)
+	}
+}
+
+// WithLocalEnv adds the NAME=value variables which differ on every machine, such as paths,
+// to the environment of the shell. They are left out of the recorded environment.
+func WithLocalEnv(env ...string) Option {
+	return func(p *Player) {
+		p.localEnv = append(p.localEnv, env...)
+	}
+}
+
+// shellCommand returns the command starting the shell in dir,
+// and a function removing what it needs once the shell exited.
+func (p *Player) shellCommand(ctx context.Context, dir string) (*exec.Cmd, func(), error) {
+	c := exec.CommandContext(ctx, p.shell, p.args...)
+	c.Dir = dir
+	if !p.hermetic {
+		if len(p.env) != 0 || len(p.localEnv) != 0 {
+			// The variables passed through are in the environment already.
+			c.Env = append(os.Environ(), p.localEnv...)
+			for _, kv := range p.env {
+				if strings.Contains(kv, "=") {
+					c.Env = append(c.Env, kv)
+				}
+			}
+		}
+		return c, func() {}, nil
+	}
+
+	home, err := os.MkdirTemp("", "democtl-home-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		os.RemoveAll(home)
+	}
+
+	args, rcEnv, err := hermeticArgs(p.shell, p.rcFile, home)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	c.Args = append(append([]string{p.shell}, args...), p.args...)
+
+	env := []string{
+		"HOME=" + home,
+		"PATH=" + os.Getenv("PATH"),
+		"SHELL=" + p.shell,
+		"TERM=xterm-256color",
+		"LANG=C.UTF-8",
+		fmt.Sprintf("COLUMNS=%d", p.cols),
+		fmt.Sprintf("LINES=%d", p.rows),
+		"PS1=" + knownPrompt,
+	}
+	c.Env = append(append(env, rcEnv...), p.localEnv...)
+
+	// Only the variables which are the same on every machine are recorded,
+	// the later variables take precedence, as they do for the shell.
+	p.recordEnv = map[string]string{
+		"HOME": temporaryHome,
+	}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if recordedEnv[name] {
+			p.recordEnv[name] = value
+		}
+	}
+	for _, kv := range p.env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			value, ok = os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			c.Env = append(c.Env, name+"="+value)
+			p.recordEnv[name] = redacted
+			continue
+		}
+		c.Env = append(c.Env, kv)
+		p.recordEnv[name] = value
+	}
+	return c, cleanup, nil
+}
+
+// recordedEnv are the variables of the clean environment recorded in the header.
+var recordedEnv = map[string]bool{
+	"TERM":    true,
+	"LANG":    true,
+	"SHELL":   true,
+	"COLUMNS": true,
+	"LINES":   true,
+	"PS1":     true,
+}
+
+const (
+	// temporaryHome is recorded in place of the HOME, which is a new directory every time.
+	temporaryHome = "<temporary>"
+	// redacted is recorded in place of the values of the variables passed through,
+	// which may be secrets.
+	redacted = "<redacted>"
+)
+
+// hermeticArgs returns the arguments and the variables of the shell
+// leaving out its rc files, or reading rcFile only.
+func hermeticArgs(shell, rcFile, home string) ([]string, []string, error) {
+	if rcFile != "" {
+		var err error
+		rcFile, err = filepath.Abs(rcFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch shellName(shell) {
+	case "bash":
+		if rcFile == "" {
+			return []string{"--noprofile", "--norc"}, nil, nil
+		}
+		return []string{"--noprofile", "--rcfile", rcFile}, nil, nil
+	case "zsh":
+		if rcFile == "" {
+			return []string{"--no-rcs"}, nil, nil
+		}
+		// The .zshrc in the temporary HOME is the only rc file read.
+		data, err := os.ReadFile(rcFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = os.WriteFile(filepath.Join(home, ".zshrc"), data, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"--no-globalrcs"}, nil, nil
+	case "fish":
+		// fish has no PS1, the prompt is set by a function.
+		args := []string{"--no-config", "--init-command", "function fish_prompt; printf '" + knownPrompt + "'; end"}
+		if rcFile != "" {
+			args = append(args, "--init-command", "source '"+strings.ReplaceAll(rcFile, "'", `\'`)+"'")
+		}
+		return args, nil, nil
+	default:
+		// sh reads the file named by ENV only, which the clean environment does not set.
+		if rcFile == "" {
+			return nil, nil, nil
+		}
+		return nil, []string{"ENV=" + rcFile}, nil
+	}
+}
@@ -0,0 +1,82 @@
+package player
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// expect checks that the output of the last command matches re.
+func (p *Player) expect(re *regexp.Regexp) error {
+	output := p.getOutput()
+	if re.MatchString(output) {
+		return nil
+	}
+	return fmt.Errorf("expect %q does not match the output:\n%s", re, output)
+}
+
+// expectScreen checks that the emulated screen is the same as the file,
+// the trailing spaces and empty lines are ignored.
+func (p *Player) expectScreen(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	expected := trimScreen(string(data))
+	actual := p.screenText()
+	if expected == actual {
+		return nil
+	}
+	return fmt.Errorf("expect-screen %s does not match the screen:\n%s", file, diffLines(expected, actual))
+}
+
+// screenText returns the text of the emulated screen.
+func (p *Player) screenText() string {
+	cols, rows := p.screen.Size()
+	var b strings.Builder
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			b.WriteRune(p.screen.Cell(x, y).Char)
+		}
+		b.WriteByte('\n')
+	}
+	return trimScreen(b.String())
+}
+
+func trimScreen(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \x00")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+// diffLines returns the lines that differ, the expected
+// ones prefixed with "-" and the actual ones with "+".
+func diffLines(expected, actual string) string {
+	e := strings.Split(expected, "\n")
+	a := strings.Split(actual, "\n")
+	var b strings.Builder
+	for i := 0; i < max(len(e), len(a)); i++ {
+		var el, al string
+		if i < len(e) {
+			el = e[i]
+		}
+		if i < len(a) {
+			al = a[i]
+		}
+		if el == al {
+			fmt.Fprintf(&b, " %s\n", el)
+			continue
+		}
+		if i < len(e) {
+			fmt.Fprintf(&b, "-%s\n", el)
+		}
+		if i < len(a) {
+			fmt.Fprintf(&b, "+%s\n", al)
+		}
+	}
+	return b.String()
+}
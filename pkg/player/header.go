@@ -0,0 +1,111 @@
+package player
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/script"
+	"github.com/wzshiming/democtl/pkg/utils"
+)
+
+// WithTitle sets the title of the recording, over the one set by @title.
+func WithTitle(title string) Option {
+	return func(p *Player) {
+		p.title = title
+	}
+}
+
+// WithProfileHash records the hash of the profile the recording is rendered with,
+// to find out when to record it again.
+func WithProfileHash(hash string) Option {
+	return func(p *Player) {
+		p.profileHash = hash
+	}
+}
+
+// WithSettingsHash records the hash of the shell and the environment settings
+// the recording is made with, to find out when to record it again.
+func WithSettingsHash(hash string) Option {
+	return func(p *Player) {
+		p.settingsHash = hash
+	}
+}
+
+// WithOutputFile sets the file the recording is written to,
+// the path of the script is recorded relative to it.
+func WithOutputFile(name string) Option {
+	return func(p *Player) {
+		p.outputFile = name
+	}
+}
+
+// WithDebug sets where the output of the shell is echoed while recording, os.Stdout by default.
+func WithDebug(w io.Writer) Option {
+	return func(p *Player) {
+		p.debug = w
+	}
+}
+
+// header returns the header of the recording of s, which is nil for a session typed by hand.
+func (p *Player) header(s *script.Script) cast.Header {
+	h := cast.Header{
+		Width:     int(p.cols),
+		Height:    int(p.rows),
+		Timestamp: time.Now().Unix(),
+		Title:     p.title,
+		Env:       p.recordEnv,
+		Democtl: &cast.Democtl{
+			Version:  utils.Version(),
+			Profile:  p.profileHash,
+			Settings: p.settingsHash,
+			Hermetic: p.hermetic,
+		},
+	}
+	if h.Env == nil {
+		h.Env = map[string]string{
+			"SHELL": p.shell,
+		}
+		if term := os.Getenv("TERM"); term != "" {
+			h.Env["TERM"] = term
+		}
+	}
+	if s != nil {
+		if h.Title == "" {
+			h.Title = s.Title
+		}
+		if s.Name != "" {
+			h.Democtl.Source = p.sourcePath(s.Name)
+		}
+		h.Democtl.Hash = s.Hash
+		for name := range s.Vars {
+			h.Democtl.Vars = append(h.Democtl.Vars, name)
+		}
+		sort.Strings(h.Democtl.Vars)
+	}
+	return h
+}
+
+// sourcePath returns the path of the script relative to the output file,
+// or the absolute path when the output file is unknown.
+func (p *Player) sourcePath(name string) string {
+	source, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if p.outputFile == "" {
+		return source
+	}
+	dir, err := filepath.Abs(filepath.Dir(p.outputFile))
+	if err != nil {
+		return source
+	}
+	rel, err := filepath.Rel(dir, source)
+	if err != nil {
+		return source
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,39 @@
+package player
+
+// hide stops recording the output, the commands still run in the shell.
+func (p *Player) hide() {
+	if p.hidden {
+		return
+	}
+	p.hidden = true
+	p.hiddenAt = p.clock.Now()
+}
+
+// show records the output again. The screen did not see the hidden output,
+// so the current prompt is written again over the last line, or on a clear screen.
+func (p *Player) show(clear bool) error {
+	if !p.hidden {
+		return nil
+	}
+	now := p.clock.Now()
+	if p.baseTime != 0 {
+		p.baseTime += now - p.hiddenAt
+	}
+	p.hidden = false
+
+	prompt := getPrompt(p.getHistory())
+	history := p.getHistory()
+
+	sync := "\r\x1b[K"
+	if clear {
+		sync = "\x1b[H\x1b[2J"
+	}
+	err := p.record(append([]byte(sync), prompt...), now)
+	if err != nil {
+		return err
+	}
+
+	// The written prompt is not output of the commands.
+	p.history = history
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package player
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyInput copies the keys typed in the terminal of stdin to w until stop is closed,
+// stdin is polled so no read is left to take a key once the session ended.
+func copyInput(w io.Writer, stdin *os.File, stop <-chan struct{}) {
+	fd := int(stdin.Fd())
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	buf := make([]byte, 4096)
+	for {
+		n, err := unix.Poll(fds, 100)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		n, err = stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		_, err = w.Write(buf[:n])
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package player
+
+import (
+	"io"
+	"os"
+)
+
+// copyInput copies the keys typed in the console of stdin to w until stop is closed,
+// windows can't poll the console so the last read is left until the next key.
+func copyInput(w io.Writer, stdin *os.File, stop <-chan struct{}) {
+	go func() {
+		_, _ = io.Copy(w, stdin)
+	}()
+	<-stop
+}
@@ -0,0 +1,142 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/vt10x"
+	"golang.org/x/term"
+)
+
+// Interact records a session typed by hand in the terminal of stdin,
+// until the shell exits. The size of the terminal is used when
+// the player has no rows or cols.
+func (p *Player) Interact(ctx context.Context, stdin *os.File, out io.Writer, dir string) error {
+	fd := int(stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("interactive recording needs a terminal")
+	}
+
+	if p.rows == 0 || p.cols == 0 {
+		size, err := pty.GetsizeFull(stdin)
+		if err != nil {
+			return err
+		}
+		p.rows = size.Rows
+		p.cols = size.Cols
+	}
+
+	c, cleanup, err := p.shellCommand(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	p.encoder = cast.NewEncoder(out, cast.WithVersion(p.castVersion))
+	err = p.encoder.EncodeHeader(p.header(nil))
+	if err != nil {
+		return err
+	}
+
+	p.dir = dir
+	p.screen = vt10x.New(vt10x.WithSize(int(p.cols), int(p.rows)))
+
+	ptmx, err := pty.StartWithSize(c, &pty.Winsize{
+		Rows: p.rows,
+		Cols: p.cols,
+	})
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	defer signal.Stop(resize)
+
+	// The keys are forwarded as they are, the shell echoes them,
+	// until the session ends.
+	stop := make(chan struct{})
+	inputDone := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-inputDone
+	}()
+	go func() {
+		defer close(inputDone)
+		copyInput(ptmx, stdin, stop)
+	}()
+
+	output := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := ptmx.Read(buf)
+			if n != 0 {
+				select {
+				case output <- buf[:n]:
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case b := <-output:
+			err = p.record(b, p.clock.Now())
+			if err != nil {
+				return err
+			}
+		case <-resize:
+			size, err := pty.GetsizeFull(stdin)
+			if err != nil {
+				return err
+			}
+			err = pty.Setsize(ptmx, size)
+			if err != nil {
+				return err
+			}
+			p.screen.Resize(int(size.Cols), int(size.Rows))
+			err = p.encodeEvent(cast.ResizeEvent, fmt.Sprintf("%dx%d", size.Cols, size.Rows), p.clock.Now())
+			if err != nil {
+				return err
+			}
+		case err := <-readErr:
+			// Reading the pty fails with EIO once the shell has exited.
+			if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) {
+				return err
+			}
+			err = c.Wait()
+			if err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					return err
+				}
+			}
+			return ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
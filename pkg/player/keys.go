@@ -0,0 +1,23 @@
+package player
+
+import (
+	"context"
+	"errors"
+)
+
+// pressKey writes the key count times, at the typing interval.
+func (p *Player) pressKey(key []byte, count int) error {
+	for i := 0; i < count; i++ {
+		p.clock.Sleep(p.keyDelay(p.typing, 0))
+		_, err := p.ptmx.Write(key)
+		if err != nil {
+			return err
+		}
+		// Not every key writes something back.
+		err = p.readOutput(p.typing.interval)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,23 +1,24 @@
 package player
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
-	"os/exec"
-	"strconv"
+	"regexp"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/creack/pty"
-	"github.com/google/shlex"
 	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/script"
+	"github.com/wzshiming/democtl/pkg/utils"
 	"github.com/wzshiming/getch"
+	"github.com/wzshiming/vt10x"
 )
 
 type Player struct {
@@ -26,39 +27,154 @@ type Player struct {
 
 	history []byte
 
+	// hidden is set between @hide and @show, the output is not recorded
+	// and the time since hiddenAt is left out of the recording.
+	hidden   bool
+	hiddenAt int64
+	// typed is the length of the history when the last command was typed,
+	// the output of the command follows it.
+	typed int
+
 	shell string
+	args  []string
+	dir   string
+	title string
+	// profileHash and settingsHash are recorded in the header for democtl build,
+	// the script is recorded relative to outputFile.
+	profileHash  string
+	settingsHash string
+	outputFile   string
+
+	// hermetic starts the shell with a clean environment, with rcFile only,
+	// env is added to the environment and recordEnv is the one recorded in the header,
+	// localEnv is added before env and left out of the header.
+	hermetic  bool
+	rcFile    string
+	env       []string
+	localEnv  []string
+	recordEnv map[string]string
+
 	debug io.Writer
 	rows  uint16
 	cols  uint16
 
-	encoder *cast.Encoder
+	// screen emulates the terminal for the checks of the screen.
+	screen vt10x.Terminal
+
+	encoder     *cast.Encoder
+	castVersion int
 
 	ptmx           *os.File
 	bufferedReader *bufferedReader
 
-	typingInterval time.Duration
+	// prompt tells when a command finished, setPrompt sets a known prompt in the shell.
+	// prompts keeps the prompts before the spawned programs.
+	// promptTimeout is how long a command may take to give the prompt back, 0 waits forever.
+	prompt        promptMatcher
+	prompts       []promptMatcher
+	setPrompt     bool
+	promptTimeout time.Duration
+
+	// shellIntegration injects the hooks of the OSC 133 marks into the shell,
+	// lastCommand is the command whose exit status is checked next
+	// against expectedExit, which nextExit replaces for the next command.
+	shellIntegration bool
+	lastCommand      string
+	expectedExit     *int
+	nextExit         *int
+
+	// typing is how the commands are typed, nextTyping
+	// replaces it for the next command only.
+	typing     typing
+	nextTyping *typing
+	rand       *rand.Rand
+
+	clock clock
+}
+
+type Option func(*Player)
+
+// WithArgs sets the arguments of the shell, which may be any
+// interactive program such as a REPL.
+func WithArgs(args ...string) Option {
+	return func(p *Player) {
+		p.args = args
+	}
+}
+
+// WithVirtualClock makes the times of the events independent of the speed
+// of the machine, the typing and the sleeps do not take real time
+// and the time between the outputs is capped to maxGap.
+func WithVirtualClock(maxGap time.Duration) Option {
+	return func(p *Player) {
+		p.clock = newVirtualClock(maxGap)
+	}
+}
+
+// WithPrompt sets the regex of the last line of the prompt,
+// instead of guessing the prompt from the output of the shell.
+func WithPrompt(re *regexp.Regexp) Option {
+	return func(p *Player) {
+		p.prompt = regexpPrompt{re: re}
+	}
+}
+
+// WithPromptTimeout sets how long to wait for the prompt after a command,
+// the recording fails after that. 0 waits forever.
+func WithPromptTimeout(timeout time.Duration) Option {
+	return func(p *Player) {
+		p.promptTimeout = timeout
+	}
+}
+
+// WithSetPrompt sets a known prompt in the shell before recording.
+func WithSetPrompt() Option {
+	return func(p *Player) {
+		p.setPrompt = true
+	}
 }
 
-func NewPlayer(shell string, rows, cols uint16) *Player {
-	return &Player{
-		buffer:         make([]byte, 1024),
-		shell:          shell,
-		debug:          os.Stdout,
-		rows:           rows,
-		cols:           cols,
-		typingInterval: time.Second / 10,
+// WithShellIntegration injects the shell integration hooks into bash, zsh or fish,
+// to know when a command finished and its exit status by the OSC 133 marks.
+// The exit statuses are recorded as markers and a non-zero one fails the recording.
+func WithShellIntegration() Option {
+	return func(p *Player) {
+		p.shellIntegration = true
 	}
 }
 
+// WithCastVersion sets the version of the cast format to write.
+func WithCastVersion(version int) Option {
+	return func(p *Player) {
+		p.castVersion = version
+	}
+}
+
+func NewPlayer(shell string, rows, cols uint16, options ...Option) *Player {
+	p := &Player{
+		buffer:      make([]byte, 1024),
+		shell:       shell,
+		debug:       os.Stdout,
+		rows:        rows,
+		cols:        cols,
+		typing:      typing{interval: time.Second / 10},
+		rand:        rand.New(rand.NewSource(1)),
+		castVersion: 2,
+		clock:       realClock{},
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
 func (p *Player) readOutput(timeout time.Duration) error {
 	n, retErr := p.readWithTimeout(p.buffer, timeout)
 	if retErr != nil && retErr != io.EOF {
 		return retErr
 	}
 
-	now := time.Now()
-
-	err := p.record(p.buffer[:n], now.UnixMicro())
+	err := p.record(p.buffer[:n], p.clock.Now())
 	if err != nil {
 		return err
 	}
@@ -67,23 +183,37 @@ func (p *Player) readOutput(timeout time.Duration) error {
 }
 
 func (p *Player) record(b []byte, baseTime int64) error {
+	p.pushHistory(b)
+
+	if p.hidden {
+		return nil
+	}
+
 	_, err := p.debug.Write(b)
 	if err != nil {
 		return err
 	}
 
+	_, err = p.screen.Write(b)
+	if err != nil {
+		return err
+	}
+
+	return p.encodeEvent(cast.OutputEvent, string(b), baseTime)
+}
+
+func (p *Player) encodeEvent(typ cast.EventType, data string, baseTime int64) error {
 	if p.baseTime == 0 {
 		p.baseTime = baseTime
 	}
 
-	p.pushHistory(b)
-
 	event := cast.Event{
 		Time: float64(baseTime-p.baseTime) / float64(time.Millisecond),
-		Data: string(b),
+		Type: typ,
+		Data: data,
 	}
 
-	err = p.encoder.EncodeEvent(event)
+	err := p.encoder.EncodeEvent(event)
 	if err != nil {
 		return err
 	}
@@ -92,6 +222,7 @@ func (p *Player) record(b []byte, baseTime int64) error {
 
 func (p *Player) clearHistory() {
 	p.history = p.history[:0]
+	p.typed = 0
 }
 
 func (p *Player) pushHistory(data []byte) {
@@ -102,6 +233,13 @@ func (p *Player) getHistory() []byte {
 	return p.history
 }
 
+// getOutput returns the text written after the last command was typed,
+// without the escape sequences and the end of the typed line.
+func (p *Player) getOutput() string {
+	output := utils.StripANSI(string(p.history[p.typed:]))
+	return strings.TrimPrefix(output, "\n")
+}
+
 func (p *Player) getPrompt(target []byte, timeout time.Duration) ([]byte, error) {
 	end := time.Now().Add(timeout)
 	for {
@@ -158,12 +296,12 @@ func getShortestPrompt(prompt []byte) []byte {
 	r := strings.LastIndexFunc(str, func(r rune) bool {
 		return !unicode.IsSpace(r)
 	})
-
-	s := 0
-	if r != -1 {
-		s = len(str) - r
+	if r == -1 {
+		return prompt
 	}
 
+	s := len(str) - r
+
 	l := strings.LastIndexFunc(str[:r], unicode.IsSpace)
 	if l >= 0 {
 		str = str[l+1:]
@@ -200,12 +338,25 @@ func (p *Player) mustGetPrompt(target []byte) ([]byte, error) {
 	if bytes.Equal(prompt1, prompt3) {
 		return prompt1, nil
 	}
-	return nil, fmt.Errorf("can't get prompt %s, %s, %s", prompt1, prompt2, prompt3)
+	return nil, fmt.Errorf("can't get prompt %q, %q, %q: set it with a prompt regex or set a known prompt", prompt1, prompt2, prompt3)
 }
 
-func (p *Player) waitFinish() error {
-	for {
-		err := p.readOutput(time.Second)
+// promptDeadline is when to stop waiting for the prompt, zero when there is no limit.
+func (p *Player) promptDeadline() time.Time {
+	if p.promptTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.promptTimeout)
+}
+
+// waitFinish reads the output until it stops for a second, or until end unless it is zero.
+func (p *Player) waitFinish(end time.Time) error {
+	for end.IsZero() || time.Now().Before(end) {
+		timeout := time.Second
+		if !end.IsZero() {
+			timeout = min(time.Until(end), timeout)
+		}
+		err := p.readOutput(timeout)
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
 				break
@@ -216,133 +367,201 @@ func (p *Player) waitFinish() error {
 	return nil
 }
 
-func (p *Player) run(in io.Reader) error {
-	prompt, err := p.mustGetPrompt([]byte{'\n'})
+func (p *Player) run(steps []script.Step) error {
+	err := p.initPrompt()
 	if err != nil {
 		return err
 	}
 
-	shortestPrompt := getShortestPrompt(prompt)
-
-	first := true
-	reader := bufio.NewReader(in)
-	for {
-		if first {
-			err = p.record(prompt, time.Now().UnixMicro())
-			if err != nil {
-				return err
-			}
-			first = false
-		} else {
-			err = p.waitFinish()
+	// running is set while the last command has not given the prompt back,
+	// continued is set when the last line ends with a backslash.
+	// commandAt is the position of the last command in the scripts.
+	running := false
+	continued := false
+	var commandAt script.Pos
+	for i, step := range steps {
+		// The directives that act on the running command go on without
+		// waiting, everything else waits until the command finishes.
+		if running && !continued && !script.ActsOnCommand(step) {
+			err = p.waitPrompt()
 			if err != nil {
 				if err == io.EOF {
-					return nil
+					return exited(steps[i:])
 				}
-				return err
-			}
-
-			if !bytes.HasSuffix(p.getHistory(), shortestPrompt) {
-				time.Sleep(time.Second / 10)
-				continue
+				return fmt.Errorf("%s: %w", commandAt, err)
 			}
+			running = false
 		}
-		p.clearHistory()
 
-		haveNext := true
-		for haveNext {
-			line, _, err := reader.ReadLine()
+		command, ok := step.(script.Command)
+		if !ok {
+			err = p.directive(step)
 			if err != nil {
 				if err == io.EOF {
-					return nil
+					return exited(steps[i+1:])
 				}
-				return err
+				return fmt.Errorf("%s: %w", step.Position(), err)
 			}
+			continue
+		}
 
-			c, err := p.builtinCommand(line)
-			if err != nil {
-				return err
-			}
-			if c {
-				continue
-			}
+		// The history keeps the output of the last command
+		// until the next one is typed, for the directives in between.
+		if !continued {
+			p.clearHistory()
+			p.startCommand(trimCommand(command.Line))
+			commandAt = command.Pos
+		} else if len(p.prompts) == 0 {
+			p.lastCommand += " " + trimCommand(command.Line)
+		}
 
-			haveNext = bytes.HasSuffix(line, []byte{'\\'})
-			err = p.command(line)
-			if err != nil {
-				return err
-			}
+		continued = command.Continued()
+		err = p.command([]byte(command.Line))
+		if err != nil {
+			return fmt.Errorf("%s: %w", command.Pos, err)
 		}
+		running = true
 	}
-}
 
-func (p *Player) builtinCommand(line []byte) (bool, error) {
-	if !bytes.HasPrefix(line, []byte{'@'}) {
-		return false, nil
+	if running {
+		err = p.waitPrompt()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %w", commandAt, err)
+		}
 	}
-	args, err := shlex.Split(string(line[1:]))
-	if err != nil {
-		return false, err
+	return nil
+}
+
+// exited returns the error of the shell exiting before the steps,
+// which is nil unless an assertion is left unchecked among them.
+func exited(steps []script.Step) error {
+	for _, step := range steps {
+		if script.IsAssertion(step) {
+			pos := step.Position()
+			return fmt.Errorf("%s: shell exited before line %d", pos, pos.Line)
+		}
 	}
-	switch args[0] {
-	case "pause":
+	return nil
+}
+
+func (p *Player) directive(step script.Step) error {
+	switch step := step.(type) {
+	case script.Pause:
 		_, _, _ = getch.Getch()
-	case "sleep":
-		if len(args) != 2 {
-			return false, fmt.Errorf("sleep expects 2 arguments, got %d", len(args))
-		}
-		sleepDuration, err := strconv.ParseFloat(args[1], 64)
-		if err != nil {
-			return false, err
-		}
-		time.Sleep(time.Duration(sleepDuration) * time.Second)
-	case "typing-interval":
-		if len(args) != 2 {
-			return false, fmt.Errorf("typing-interval expects 2 arguments, got %d", len(args))
-		}
-		interval, err := strconv.ParseFloat(args[1], 64)
-		if err != nil {
-			return true, err
+	case script.Sleep:
+		p.clock.Sleep(step.Duration)
+	case script.Typing:
+		if step.Line {
+			t := p.typing
+			p.setTyping(&t, step)
+			p.nextTyping = &t
+		} else {
+			p.setTyping(&p.typing, step)
 		}
-		p.typingInterval = time.Duration(interval) * time.Second
+	case script.WaitFor:
+		return p.waitFor(step.Regexp, step.Timeout)
+	case script.Key:
+		return p.pressKey(step.Sequence, step.Count)
+	case script.Prompt:
+		p.prompt = regexpPrompt{re: step.Regexp}
+	case script.ExpectExit:
+		status := step.Status
+		p.nextExit = &status
+	case script.Spawn:
+		return p.spawn(step)
+	case script.Hide:
+		p.hide()
+	case script.Show:
+		return p.show(step.Clear)
+	case script.Expect:
+		return p.expect(step.Regexp)
+	case script.ExpectScreen:
+		return p.expectScreen(step.File)
 	default:
-		return false, fmt.Errorf("unknown command: %s", args[0])
+		return fmt.Errorf("unsupported step %T", step)
 	}
-	return true, nil
+	return nil
 }
 
-func (p *Player) command(line []byte) error {
-	time.Sleep(p.typingInterval)
-	for i := range line {
-		_, err := p.ptmx.Write(line[i : i+1])
-		if err != nil {
-			return err
+// waitFor reads the output until the output of the last command matches re.
+func (p *Player) waitFor(re *regexp.Regexp, timeout time.Duration) error {
+	end := time.Now().Add(timeout)
+	for !re.MatchString(p.getOutput()) {
+		remaining := time.Until(end)
+		if remaining <= 0 {
+			return fmt.Errorf("timed out after %s waiting for %q", timeout, re)
 		}
-		err = p.readOutput(time.Second)
+		err := p.readOutput(min(remaining, time.Second/10))
 		if err != nil {
-			return err
+			if err == io.EOF {
+				return fmt.Errorf("shell exited while waiting for %q", re)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
 		}
-		time.Sleep(p.typingInterval)
 	}
-	_, err := p.ptmx.Write([]byte{'\n'})
+	return nil
+}
+
+// startCommand sets the command whose exit status is checked,
+// the lines typed into a spawned program are left out.
+func (p *Player) startCommand(command string) {
+	if len(p.prompts) != 0 {
+		return
+	}
+	p.lastCommand = command
+	p.expectedExit = p.nextExit
+	p.nextExit = nil
+}
+
+func (p *Player) command(line []byte) error {
+	t := p.typing
+	if p.nextTyping != nil {
+		t = *p.nextTyping
+		p.nextTyping = nil
+	}
+	err := p.typeLine(t, line)
+	if err != nil {
+		return err
+	}
+	_, err = p.ptmx.Write([]byte{'\n'})
 	if err != nil {
 		return err
 	}
+	p.typed = len(p.history)
 	return nil
 }
 
+// Run parses the script and plays it, see Play.
 func (p *Player) Run(ctx context.Context, in io.Reader, out io.Writer, dir string) error {
-	p.encoder = cast.NewEncoder(out)
-	err := p.encoder.EncodeHeader(cast.Header{
-		Width:  int(p.cols),
-		Height: int(p.rows),
-	})
+	s, err := script.Parse("input", dir, in)
+	if err != nil {
+		return err
+	}
+	// The script has no file to record as its source.
+	s.Name = ""
+	return p.Play(ctx, s, out, dir)
+}
+
+// Play types the commands of the script into the shell started in dir,
+// and writes the recording to out.
+func (p *Player) Play(ctx context.Context, s *script.Script, out io.Writer, dir string) error {
+	c, cleanup, err := p.shellCommand(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	p.encoder = cast.NewEncoder(out, cast.WithVersion(p.castVersion))
+	err = p.encoder.EncodeHeader(p.header(s))
 	if err != nil {
 		return err
 	}
 
-	c := exec.CommandContext(ctx, p.shell)
-	c.Dir = dir
+	p.dir = dir
+	p.screen = vt10x.New(vt10x.WithSize(int(p.cols), int(p.rows)))
+
 	ptmx, err := pty.StartWithSize(c, &pty.Winsize{
 		Rows: p.rows,
 		Cols: p.cols,
@@ -355,7 +574,11 @@ func (p *Player) Run(ctx context.Context, in io.Reader, out io.Writer, dir strin
 	p.ptmx = ptmx
 	p.bufferedReader = newBufferedReader(ptmx)
 	go p.bufferedReader.Run()
-	return p.run(in)
+	err = p.run(s.Steps)
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 func (p *Player) readWithTimeout(buffer []byte, timeout time.Duration) (int, error) {
@@ -0,0 +1,162 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/democtl/pkg/utils"
+)
+
+// promptMatcher reports whether the output ends with the prompt,
+// which means the command finished.
+// String describes the prompt in the errors.
+type promptMatcher interface {
+	Match(history []byte) bool
+	String() string
+}
+
+// suffixPrompt matches the output that ends with the bytes.
+type suffixPrompt []byte
+
+func (m suffixPrompt) Match(history []byte) bool {
+	return bytes.HasSuffix(history, m)
+}
+
+func (m suffixPrompt) String() string {
+	return fmt.Sprintf("%q", []byte(m))
+}
+
+// regexpPrompt matches the last line of the output, without the escape sequences.
+type regexpPrompt struct {
+	re *regexp.Regexp
+}
+
+func (m regexpPrompt) Match(history []byte) bool {
+	text := utils.StripANSI(string(history))
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	}
+	return m.re.MatchString(text)
+}
+
+func (m regexpPrompt) String() string {
+	return fmt.Sprintf("matching %q", m.re)
+}
+
+// knownPrompt is the prompt set in the shell by WithSetPrompt,
+// knownPromptRegexp matches it with the escape sequences the shell writes after it.
+const knownPrompt = "$ "
+
+var knownPromptRegexp = regexp.MustCompile(regexp.QuoteMeta(knownPrompt) + "$")
+
+// setPromptCommands set the known prompt by the name of the shell, and clear the right side prompt.
+// The hooks of bash and zsh are cleared too, as the prompt themes reset the prompt from them.
+// The command of sh is for the other shells, fish has functions instead of PS1.
+var setPromptCommands = map[string]string{
+	"sh":   "unset PROMPT_COMMAND; PS1='" + knownPrompt + "' PROMPT='" + knownPrompt + "' RPROMPT= RPS1=\n",
+	"bash": "unset PROMPT_COMMAND; PS1='" + knownPrompt + "'\n",
+	"zsh":  "precmd_functions=(); preexec_functions=(); unset -f precmd preexec 2>/dev/null; PROMPT='" + knownPrompt + "' RPROMPT= RPS1=\n",
+	"fish": "function fish_prompt; printf '" + knownPrompt + "'; end; function fish_right_prompt; end\n",
+}
+
+// setPromptCommand returns the command setting the known prompt in the shell.
+func setPromptCommand(shell string) string {
+	command, ok := setPromptCommands[shellName(shell)]
+	if !ok {
+		return setPromptCommands["sh"]
+	}
+	return command
+}
+
+// shellName returns the name of the shell, without its directory and extension.
+func shellName(shell string) string {
+	return strings.TrimSuffix(filepath.Base(shell), ".exe")
+}
+
+// initPrompt waits for the first prompt of the shell and records it.
+func (p *Player) initPrompt() error {
+	switch {
+	case p.shellIntegration:
+		return p.initShellIntegration()
+	case p.prompt != nil:
+		return p.waitPrompt()
+	case p.setPrompt:
+		_, err := p.ptmx.Write([]byte(setPromptCommand(p.shell)))
+		if err != nil {
+			return err
+		}
+		// Leave out the command and the old prompts.
+		p.prompt = regexpPrompt{re: knownPromptRegexp}
+		err = p.drainUntil(p.prompt, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		return p.record([]byte(knownPrompt), p.clock.Now())
+	default:
+		prompt, err := p.mustGetPrompt([]byte{'\n'})
+		if err != nil {
+			return err
+		}
+		p.prompt = suffixPrompt(getShortestPrompt(prompt))
+		return p.record(prompt, p.clock.Now())
+	}
+}
+
+// drainUntil reads and drops the output until it ends with the prompt
+// and the shell stays quiet for a while.
+func (p *Player) drainUntil(prompt promptMatcher, timeout time.Duration) error {
+	var dropped []byte
+	end := time.Now().Add(timeout)
+	for {
+		if time.Now().After(end) {
+			return fmt.Errorf("timed out after %s waiting for the prompt %s", timeout, prompt)
+		}
+		n, err := p.readWithTimeout(p.buffer, time.Second/2)
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			if prompt.Match(dropped) {
+				return nil
+			}
+			continue
+		}
+		dropped = append(dropped, p.buffer[:n]...)
+	}
+}
+
+// waitPrompt reads the output until the shell shows the prompt again,
+// for at most the prompt timeout when it is set.
+func (p *Player) waitPrompt() error {
+	end := p.promptDeadline()
+	for {
+		if !end.IsZero() && time.Now().After(end) {
+			return fmt.Errorf("timed out after %s waiting for the prompt %s", p.promptTimeout, p.prompt)
+		}
+		if _, ok := p.prompt.(shellMarks); ok {
+			// The marks tell when the command finished, no need to wait for the output to stop.
+			if p.matchPrompt() {
+				return p.checkExit()
+			}
+			err := p.readOutput(time.Second)
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			continue
+		}
+		err := p.waitFinish(end)
+		if err != nil {
+			return err
+		}
+		if p.matchPrompt() {
+			return p.checkExit()
+		}
+		time.Sleep(time.Second / 10)
+	}
+}
@@ -0,0 +1,8 @@
+package player
+
+import (
+	"os"
+)
+
+// notifyResize does nothing, windows has no signal for the changes of the terminal size.
+func notifyResize(c chan<- os.Signal) {}
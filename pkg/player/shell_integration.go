@@ -0,0 +1,134 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/democtl/pkg/cast"
+	"github.com/wzshiming/democtl/pkg/script"
+)
+
+// The hooks make the shell write the OSC 133 marks: A before the prompt,
+// B after it and D with the exit status when a command finished.
+// They start with a space to stay out of the history of the shell.
+var shellIntegrationHooks = map[string]string{
+	"bash": ` __democtl_exit() { printf '\033]133;D;%s\007' "$?"; }; ` +
+		`PROMPT_COMMAND="__democtl_exit${PROMPT_COMMAND:+;$PROMPT_COMMAND}"; ` +
+		`PS1='\[\e]133;A\a\]'"$PS1"'\[\e]133;B\a\]'` + "\n",
+	"zsh": ` __democtl_exit() { printf '\033]133;D;%s\007' "$?"; }; ` +
+		`precmd_functions=(__democtl_exit $precmd_functions); ` +
+		`PS1=$'%{\e]133;A\a%}'"$PS1"$'%{\e]133;B\a%}'` + "\n",
+	"fish": ` function __democtl_exit --on-event fish_postexec; printf '\e]133;D;%s\a' $status; end; ` +
+		`functions -c fish_prompt __democtl_prompt; ` +
+		`function fish_prompt; printf '\e]133;A\a'; __democtl_prompt; printf '\e]133;B\a'; end` + "\n",
+}
+
+var (
+	promptEndMark   = regexp.MustCompile(`\x1b\]133;B(?:\x07|\x1b\\)`)
+	commandDoneMark = regexp.MustCompile(`\x1b\]133;D(?:;(-?\d+))?(?:\x07|\x1b\\)`)
+)
+
+// shellMarks matches the output where a command finished and the prompt
+// after it is written, by the marks of the shell integration.
+type shellMarks struct{}
+
+func (shellMarks) Match(history []byte) bool {
+	done := commandDoneMark.FindAllIndex(history, -1)
+	if len(done) == 0 {
+		return false
+	}
+	return promptEndMark.Match(history[done[len(done)-1][1]:])
+}
+
+func (shellMarks) String() string {
+	return "of the shell integration"
+}
+
+// exitCode returns the exit status of the last finished command.
+func exitCode(history []byte) (int, bool) {
+	done := commandDoneMark.FindAllSubmatch(history, -1)
+	if len(done) == 0 || len(done[len(done)-1][1]) == 0 {
+		return 0, false
+	}
+	code, err := strconv.Atoi(string(done[len(done)-1][1]))
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// initShellIntegration writes the hooks of the shell and waits for the first marked prompt.
+func (p *Player) initShellIntegration() error {
+	name := shellName(p.shell)
+	hooks, ok := shellIntegrationHooks[name]
+	if !ok {
+		return fmt.Errorf("shell integration supports bash, zsh and fish, not %s", name)
+	}
+	_, err := p.ptmx.Write([]byte(hooks))
+	if err != nil {
+		return err
+	}
+
+	// Leave out the hooks and the prompts before them.
+	var dropped []byte
+	end := time.Now().Add(10 * time.Second)
+	for {
+		if time.Now().After(end) {
+			return fmt.Errorf("timed out waiting for the prompt of the shell integration")
+		}
+		n, err := p.readWithTimeout(p.buffer, time.Second/2)
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			if promptEndMark.Match(dropped) {
+				break
+			}
+			continue
+		}
+		dropped = append(dropped, p.buffer[:n]...)
+	}
+
+	p.prompt = shellMarks{}
+	return p.record(getPrompt(dropped), p.clock.Now())
+}
+
+// checkExit records the exit status of the command as a marker,
+// and fails when it is not the expected one.
+func (p *Player) checkExit() error {
+	if _, ok := p.prompt.(shellMarks); !ok || p.lastCommand == "" {
+		return nil
+	}
+	code, ok := exitCode(p.getHistory())
+	if !ok {
+		return nil
+	}
+	command := p.lastCommand
+	p.lastCommand = ""
+
+	if !p.hidden {
+		err := p.encodeEvent(cast.MarkerEvent, fmt.Sprintf("%s (exit %d)", command, code), p.clock.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.expectedExit == nil {
+		if code != 0 {
+			return fmt.Errorf("command %q exited with %d", command, code)
+		}
+	} else if *p.expectedExit != script.AnyExit && code != *p.expectedExit {
+		return fmt.Errorf("command %q exited with %d, expected %d", command, code, *p.expectedExit)
+	}
+	return nil
+}
+
+// trimCommand returns the command for the label of a marker.
+func trimCommand(line string) string {
+	return strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+}
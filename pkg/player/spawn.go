@@ -0,0 +1,73 @@
+package player
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wzshiming/democtl/pkg/script"
+)
+
+// spawn types the command of a program, such as a REPL, and waits for its prompt.
+// The lines after it are typed into the program until it exits
+// and the prompt before it shows again.
+func (p *Player) spawn(step script.Spawn) error {
+	var prompt promptMatcher
+	if step.Prompt != nil {
+		prompt = regexpPrompt{re: step.Prompt}
+	}
+
+	line := quoteArgs(step.Args)
+	p.clearHistory()
+	p.startCommand(line)
+	err := p.command([]byte(line))
+	if err != nil {
+		return err
+	}
+
+	if prompt == nil {
+		// The last line written by the program once it is quiet is its prompt.
+		err = p.waitFinish(p.promptDeadline())
+		if err != nil {
+			return err
+		}
+		guessed := getShortestPrompt(getPrompt(p.getHistory()[p.typed:]))
+		if len(strings.TrimSpace(string(guessed))) == 0 {
+			return fmt.Errorf("can't guess the prompt of %s: set it with --prompt", step.Args[0])
+		}
+		prompt = suffixPrompt(guessed)
+	}
+
+	p.prompts = append(p.prompts, p.prompt)
+	p.prompt = prompt
+	return p.waitPrompt()
+}
+
+// matchPrompt reports whether the output ends with the prompt. It goes back
+// to the prompt before a spawned program when the program exited.
+func (p *Player) matchPrompt() bool {
+	history := p.getHistory()
+	if p.prompt.Match(history) {
+		return true
+	}
+	for i := len(p.prompts) - 1; i >= 0; i-- {
+		if p.prompts[i].Match(history) {
+			p.prompt = p.prompts[i]
+			p.prompts = p.prompts[:i]
+			return true
+		}
+	}
+	return false
+}
+
+// quoteArgs joins the arguments into a line for the shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,123 @@
+package player
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wzshiming/democtl/pkg/script"
+)
+
+// typing is how the commands are typed.
+type typing struct {
+	// interval is the average time between two keys.
+	interval time.Duration
+	// jitter is the fraction of the interval by which the time between two keys varies.
+	jitter float64
+	// pause is added after spaces and punctuation.
+	pause time.Duration
+	// typos is the probability of a wrong key, which is then erased with a backspace.
+	typos float64
+}
+
+// setTyping changes t by the settings of a typing directive.
+func (p *Player) setTyping(t *typing, step script.Typing) {
+	if step.Interval != nil {
+		t.interval = *step.Interval
+	}
+	if step.Jitter != nil {
+		t.jitter = *step.Jitter
+	}
+	if step.Pause != nil {
+		t.pause = *step.Pause
+	}
+	if step.Typos != nil {
+		t.typos = *step.Typos
+	}
+	if step.Seed != nil {
+		p.rand = rand.New(rand.NewSource(*step.Seed))
+	}
+}
+
+// keyDelay returns the time to wait before the key typed after prev,
+// there is no wait while the output is hidden.
+func (p *Player) keyDelay(t typing, prev byte) time.Duration {
+	if p.hidden {
+		return 0
+	}
+	d := float64(t.interval)
+	if t.jitter != 0 {
+		d *= 1 + t.jitter*(2*p.rand.Float64()-1)
+	}
+	if prev == ' ' || unicode.IsPunct(rune(prev)) {
+		d += float64(t.pause)
+	}
+	return time.Duration(d)
+}
+
+// typeLine types the line key by key, with the typos of t.
+func (p *Player) typeLine(t typing, line []byte) error {
+	var prev byte
+	for _, c := range line {
+		if t.typos != 0 && p.rand.Float64() < t.typos {
+			if wrong, ok := neighborKey(c, p.rand); ok {
+				err := p.typeKey(t, prev, wrong)
+				if err != nil {
+					return err
+				}
+				err = p.typeKey(t, wrong, '\x7f')
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := p.typeKey(t, prev, c)
+		if err != nil {
+			return err
+		}
+		prev = c
+	}
+	p.clock.Sleep(p.keyDelay(t, prev))
+	return nil
+}
+
+func (p *Player) typeKey(t typing, prev, c byte) error {
+	p.clock.Sleep(p.keyDelay(t, prev))
+	_, err := p.ptmx.Write([]byte{c})
+	if err != nil {
+		return err
+	}
+	return p.readOutput(time.Second)
+}
+
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// neighborKey returns a key next to c on the keyboard, for the letters and the digits.
+func neighborKey(c byte, r *rand.Rand) (byte, bool) {
+	lower := byte(unicode.ToLower(rune(c)))
+	for _, row := range keyboardRows {
+		i := strings.IndexByte(row, lower)
+		if i < 0 {
+			continue
+		}
+		var neighbors []byte
+		if i > 0 {
+			neighbors = append(neighbors, row[i-1])
+		}
+		if i < len(row)-1 {
+			neighbors = append(neighbors, row[i+1])
+		}
+		n := neighbors[r.Intn(len(neighbors))]
+		if lower != c {
+			n = byte(unicode.ToUpper(rune(n)))
+		}
+		return n, true
+	}
+	return 0, false
+}
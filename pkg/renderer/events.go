@@ -0,0 +1,44 @@
+package renderer
+
+import (
+	"github.com/wzshiming/democtl/pkg/cast"
+)
+
+// eventReader reads the events to render from a decoder one at a time.
+type eventReader struct {
+	decoder *cast.Decoder
+
+	idleTimeLimit float64
+	lastTime      float64
+	idleTime      float64
+}
+
+func newEventReader(decoder *cast.Decoder, idleTimeLimit float64) *eventReader {
+	return &eventReader{
+		decoder:       decoder,
+		idleTimeLimit: idleTimeLimit,
+	}
+}
+
+// Next returns the next event that affects the rendering,
+// with pauses longer than the idle time limit shortened to the limit.
+func (r *eventReader) Next() (cast.Event, error) {
+	for {
+		event, err := r.decoder.DecodeEvent()
+		if err != nil {
+			return cast.Event{}, err
+		}
+		if event.Type == cast.InputEvent || event.Type == cast.ExitEvent {
+			continue
+		}
+
+		if r.idleTimeLimit > 0 {
+			if pause := event.Time - r.lastTime; pause > r.idleTimeLimit {
+				r.idleTime += pause - r.idleTimeLimit
+			}
+			r.lastTime = event.Time
+			event.Time -= r.idleTime
+		}
+		return event, nil
+	}
+}
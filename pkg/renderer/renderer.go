@@ -1,7 +1,10 @@
 package renderer
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -22,39 +25,89 @@ type Frame interface {
 	Finish(ctx context.Context) error
 }
 
+// MarkerRenderer is implemented by renderers that want to know about
+// the marker events of the recording.
+type MarkerRenderer interface {
+	Marker(ctx context.Context, offset time.Duration, label string) error
+}
+
+// AbortRenderer is implemented by renderers that hold resources, such as a process,
+// to release when the rendering fails, Finish is not called then.
+type AbortRenderer interface {
+	Abort()
+}
+
+// ConcurrentRenderer is implemented by renderers whose frames can be drawn
+// and finished on several goroutines at once.
+// Frame is still called in the order of the frames.
+type ConcurrentRenderer interface {
+	Renderer
+	Concurrent() bool
+}
+
 type renderContent struct {
 	ctx context.Context
 
 	header cast.Header
-	events []cast.Event
+	events *eventReader
+
+	// width and height are the current size of the terminal,
+	// which may differ from the header after a resize event.
+	width, height int
+
+	fps     int
+	workers int
 
 	renderer Renderer
 }
 
-func Render(ctx context.Context, renderer Renderer, input io.Reader) error {
+type Option func(*renderContent)
+
+// WithFPS sets the maximum number of frames per second, default 60.
+func WithFPS(fps int) Option {
+	return func(c *renderContent) {
+		c.fps = fps
+	}
+}
+
+// WithWorkers sets the number of frames drawn at once, default 1.
+// It only applies to renderers that implement ConcurrentRenderer.
+func WithWorkers(n int) Option {
+	return func(c *renderContent) {
+		c.workers = n
+	}
+}
+
+// Render draws the frames of the cast read from input.
+// Events are decoded and drawn one at a time, so the memory used
+// does not grow with the length of the recording.
+func Render(ctx context.Context, renderer Renderer, input io.Reader, options ...Option) error {
 	decoder := cast.NewDecoder(input)
 	header, err := decoder.DecodeHeader()
 	if err != nil {
 		return err
 	}
-	var events []cast.Event
-	for {
-		event, err := decoder.DecodeEvent()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-		events = append(events, event)
-	}
 
 	c := &renderContent{
 		ctx:      ctx,
 		renderer: renderer,
 		header:   header,
-		events:   compress(events, 60),
+		events:   newEventReader(decoder, header.IdleTimeLimit),
+		width:    header.Width,
+		height:   header.Height,
+		fps:      60,
+		workers:  1,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.fps <= 0 {
+		return fmt.Errorf("invalid fps %d", c.fps)
 	}
+	if c.workers <= 0 {
+		return fmt.Errorf("invalid workers %d", c.workers)
+	}
+
 	err = frames(c)
 	if err != nil {
 		return err
@@ -76,25 +129,165 @@ func frames(c *renderContent) (err error) {
 		if err == nil {
 			err = c.renderer.Finish(c.ctx)
 		}
+		if err != nil {
+			if a, ok := c.renderer.(AbortRenderer); ok {
+				a.Abort()
+			}
+		}
 	}()
 
-	for i, event := range c.events {
-		_, err = term.Write([]byte(event.Data))
+	// Frames of a concurrent renderer are drawn by the workers
+	// from snapshots of the terminal.
+	ctx := c.ctx
+	var pool *workers
+	if r, ok := c.renderer.(ConcurrentRenderer); ok && r.Concurrent() && c.workers > 1 {
+		pool = newWorkers(c.ctx, c.workers)
+		ctx = pool.ctx
+		defer func() {
+			if err != nil {
+				pool.cancel(err)
+			}
+			werr := pool.wait()
+			if err == nil {
+				err = werr
+			}
+		}()
+	}
+
+	// Output events closer than the frame interval are written to the
+	// terminal together and drawn as a single frame at the time of the last one.
+	minInterval := 1.0 / float64(c.fps)
+	index := 0
+	pending := false
+	var pendingEvent cast.Event
+
+	// Frames that look the same as the previous one are dropped,
+	// so the previous frame lasts until the next change.
+	// The screens are compared by their content, lastScreen and screen swap their buffers.
+	var lastScreen, screen []byte
+	skipped := false
+	var skippedTime float64
+
+	flush := func(force bool) error {
+		if !pending {
+			return nil
+		}
+		pending = false
+
+		screen = appendScreen(screen[:0], c, term)
+		if index != 0 && bytes.Equal(screen, lastScreen) && !force {
+			skipped = true
+			skippedTime = pendingEvent.Time
+			return nil
+		}
+		lastScreen, screen = screen, lastScreen
+		skipped = false
+
+		f, err := c.renderer.Frame(ctx, index, time.Duration(pendingEvent.Time*float64(time.Second)))
+		if err != nil {
+			return err
+		}
+
+		// Only the part of the terminal that fits the canvas is drawn.
+		width := min(c.width, c.header.Width)
+		height := min(c.height, c.header.Height)
+		if pool != nil {
+			err = pool.draw(newSnapshot(term, width, height), width, height, f)
+		} else {
+			err = frame(ctx, term, width, height, f)
+		}
 		if err != nil {
 			return err
 		}
+		index++
+		return nil
+	}
 
-		f, err := c.renderer.Frame(c.ctx, i, time.Duration(event.Time*float64(time.Second)))
+	for ctx.Err() == nil {
+		event, err := c.events.Next()
 		if err != nil {
+			if err == io.EOF {
+				break
+			}
 			return err
 		}
-		err = frame(c, term, f)
+
+		if event.Type == cast.OutputEvent &&
+			pending &&
+			pendingEvent.Type == cast.OutputEvent &&
+			event.Time-pendingEvent.Time < minInterval {
+			_, err = term.Write([]byte(event.Data))
+			if err != nil {
+				return err
+			}
+			pendingEvent.Time = event.Time
+			continue
+		}
+
+		err = flush(false)
 		if err != nil {
 			return err
 		}
+
+		switch event.Type {
+		case cast.MarkerEvent:
+			if m, ok := c.renderer.(MarkerRenderer); ok {
+				err = m.Marker(ctx, time.Duration(event.Time*float64(time.Second)), event.Data)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		case cast.ResizeEvent:
+			width, height, err := event.Size()
+			if err != nil {
+				return err
+			}
+			c.width, c.height = width, height
+			term.Resize(width, height)
+		default:
+			_, err = term.Write([]byte(event.Data))
+			if err != nil {
+				return err
+			}
+		}
+
+		pending = true
+		pendingEvent = event
+	}
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
 	}
 
-	return nil
+	// The last frame is always drawn to keep the length of the recording.
+	if !pending && skipped {
+		pending = true
+		pendingEvent.Time = skippedTime
+	}
+	return flush(true)
+}
+
+// appendScreen appends the visible part of the terminal and the cursor to buf.
+func appendScreen(buf []byte, c *renderContent, term vt10x.Terminal) []byte {
+	width := min(c.width, c.header.Width)
+	height := min(c.height, c.header.Height)
+
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			cell := term.Cell(col, row)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(cell.Char))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(cell.FG))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(cell.BG))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(cell.Mode))
+		}
+	}
+
+	cursor := term.Cursor()
+	if term.CursorVisible() && cursor.X < width && cursor.Y < height {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(cursor.X))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(cursor.Y))
+	}
+	return buf
 }
 
 func isEmpty(text string, bg vt10x.Color, mode vt10x.AttrFlag) bool {
@@ -111,14 +304,14 @@ func isEmpty(text string, bg vt10x.Color, mode vt10x.AttrFlag) bool {
 	return false
 }
 
-func frame(c *renderContent, term vt10x.Terminal, frame Frame) (err error) {
+func frame(ctx context.Context, term screen, width, height int, frame Frame) (err error) {
 	defer func() {
 		if err == nil {
-			err = frame.Finish(c.ctx)
+			err = frame.Finish(ctx)
 		}
 	}()
 
-	for row := 0; row < c.header.Height; row++ {
+	for row := 0; row < height; row++ {
 		f := ""
 		lastCell := term.Cell(0, row)
 		lastColorFG := lastCell.FG
@@ -126,14 +319,14 @@ func frame(c *renderContent, term vt10x.Terminal, frame Frame) (err error) {
 		lastMode := lastCell.Mode
 		lastColumn := 0
 
-		for col := 0; col < c.header.Width; col++ {
+		for col := 0; col < width; col++ {
 			cell := term.Cell(col, row)
 			if cell.FG != lastColorFG ||
 				cell.BG != lastColorBG ||
 				cell.Mode != lastMode {
 				if f != "" {
 					if !isEmpty(f, lastColorBG, lastMode) {
-						err = frame.DrawText(c.ctx,
+						err = frame.DrawText(ctx,
 							lastColumn,
 							row,
 							f,
@@ -157,7 +350,7 @@ func frame(c *renderContent, term vt10x.Terminal, frame Frame) (err error) {
 
 		if f != "" {
 			if !isEmpty(f, lastColorBG, lastMode) {
-				err = frame.DrawText(c.ctx,
+				err = frame.DrawText(ctx,
 					lastColumn,
 					row,
 					f,
@@ -172,31 +365,12 @@ func frame(c *renderContent, term vt10x.Terminal, frame Frame) (err error) {
 		}
 	}
 
-	if term.CursorVisible() {
-		cursor := term.Cursor()
-		err := frame.DrawCursor(c.ctx, cursor.X, cursor.Y)
+	cursor := term.Cursor()
+	if term.CursorVisible() && cursor.X < width && cursor.Y < height {
+		err := frame.DrawCursor(ctx, cursor.X, cursor.Y)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
-
-func compress(events []cast.Event, fps int) []cast.Event {
-	var out []cast.Event
-	var minInterval = 1.0 / float64(fps)
-	for i, event := range events {
-		if i == 0 {
-			out = append(out, event)
-			continue
-		}
-		lastEvent := &out[len(out)-1]
-		if event.Time-lastEvent.Time < minInterval {
-			lastEvent.Data += event.Data
-			lastEvent.Time = event.Time
-		} else {
-			out = append(out, event)
-		}
-	}
-	return out
-}
@@ -0,0 +1,48 @@
+package renderer
+
+import (
+	"github.com/wzshiming/vt10x"
+)
+
+// screen is the part of the terminal that is read to draw a frame.
+type screen interface {
+	Cell(x, y int) vt10x.Glyph
+	Cursor() vt10x.Cursor
+	CursorVisible() bool
+}
+
+// snapshot is a copy of the visible cells of the terminal,
+// so the frame can be drawn while the terminal moves on.
+type snapshot struct {
+	width         int
+	cells         []vt10x.Glyph
+	cursor        vt10x.Cursor
+	cursorVisible bool
+}
+
+func newSnapshot(term vt10x.Terminal, width, height int) *snapshot {
+	s := &snapshot{
+		width:         width,
+		cells:         make([]vt10x.Glyph, 0, width*height),
+		cursor:        term.Cursor(),
+		cursorVisible: term.CursorVisible(),
+	}
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			s.cells = append(s.cells, term.Cell(col, row))
+		}
+	}
+	return s
+}
+
+func (s *snapshot) Cell(x, y int) vt10x.Glyph {
+	return s.cells[y*s.width+x]
+}
+
+func (s *snapshot) Cursor() vt10x.Cursor {
+	return s.cursor
+}
+
+func (s *snapshot) CursorVisible() bool {
+	return s.cursorVisible
+}
@@ -1,7 +1,6 @@
 package svg
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,6 +9,7 @@ import (
 
 	"github.com/wzshiming/democtl/pkg/renderer"
 	"github.com/wzshiming/democtl/pkg/styles"
+	"github.com/wzshiming/democtl/pkg/utils"
 	"github.com/wzshiming/vt10x"
 )
 
@@ -17,23 +17,40 @@ type canvas struct {
 	output         io.Writer
 	noWindow       bool
 	iterationCount string
+	title          string
 	getColor       func(i vt10x.Color) string
 
 	width, height int
 
 	offsets []time.Duration
 
+	// markers are written as the chapters of the animation.
+	markers []marker
+
 	stylesIndex   map[string]string
-	stylesContent []string
+	stylesCount   uint64
+	stylesPending []string
 
 	defsIndex   map[string]string
-	defsContent []string
+	defsCount   uint64
+	defsPending []string
+}
+
+// marker is a named point of the recording.
+type marker struct {
+	offset time.Duration
+	label  string
 }
 
 const (
-	rowHeight = 30
-	colWidth  = 12
-	padding   = 20
+	rowHeight   = 30
+	colWidth    = 12
+	padding     = 20
+	titleMargin = padding * 4
+
+	// maxDefs is the number of defs remembered for reuse,
+	// older defs are written again when they are used after that.
+	maxDefs = 1 << 16
 )
 
 type Option func(*canvas)
@@ -50,6 +67,13 @@ func WithGetColor(getColor func(i vt10x.Color) string) Option {
 	}
 }
 
+// WithTitle sets the title shown in the window bar.
+func WithTitle(title string) Option {
+	return func(c *canvas) {
+		c.title = title
+	}
+}
+
 func WithIterationCount(iterationCount string) Option {
 	return func(c *canvas) {
 		c.iterationCount = iterationCount
@@ -77,6 +101,21 @@ func (c *canvas) Initialize(ctx context.Context, x, y int, width, height int) er
 
 	c.createWindow()
 
+	err := c.writeStyles([]string{
+		fmt.Sprintf(`
+text {
+  font-family: Monaco,Consolas,Menlo,monospace;
+  font-size: 20px;
+  dominant-baseline: hanging;
+  text-anchor: start;
+  fill: %s;
+}
+`, c.getColor(vt10x.DefaultFG)),
+	})
+	if err != nil {
+		return err
+	}
+
 	fmt.Fprintf(c.output, `<g id="m">`)
 
 	return nil
@@ -86,20 +125,36 @@ func (c *canvas) Finish(ctx context.Context) error {
 
 	fmt.Fprintf(c.output, `</g>`)
 
-	err := c.addDefs()
+	err := c.addAnimation()
 	if err != nil {
 		return err
 	}
 
-	err = c.addStyles()
-	if err != nil {
-		return err
-	}
+	c.writeMarkers()
 
 	fmt.Fprintf(c.output, `</svg>`)
 	return nil
 }
 
+// Marker keeps the marker to write it with the chapters.
+func (c *canvas) Marker(ctx context.Context, offset time.Duration, label string) error {
+	c.markers = append(c.markers, marker{offset: offset, label: label})
+	return nil
+}
+
+// writeMarkers writes the markers in the metadata, as the chapters
+// of the animation with the second they start at.
+func (c *canvas) writeMarkers() {
+	if len(c.markers) == 0 {
+		return
+	}
+	fmt.Fprintf(c.output, `<metadata><chapters xmlns="https://github.com/wzshiming/democtl">`)
+	for _, m := range c.markers {
+		fmt.Fprintf(c.output, `<chapter start="%.3f">%s</chapter>`, m.offset.Seconds(), escapeText(m.label))
+	}
+	fmt.Fprintf(c.output, `</chapters></metadata>`)
+}
+
 func (c *canvas) Frame(ctx context.Context, index int, offset time.Duration) (renderer.Frame, error) {
 	c.offsets = append(c.offsets, offset)
 	fmt.Fprintf(c.output, `<g transform="translate(%d)">`, c.paddingRight()*index)
@@ -108,6 +163,10 @@ func (c *canvas) Frame(ctx context.Context, index int, offset time.Duration) (re
 		heightOff: c.paddingTop(),
 		widthOff:  c.paddingLeft(),
 		finish: func() error {
+			err := c.flush()
+			if err != nil {
+				return err
+			}
 			fmt.Fprintf(c.output, `</g>`)
 			return nil
 		},
@@ -150,27 +209,53 @@ func (c *canvas) createWindow() {
 			(i*(padding+buttonRadius/2))+padding, padding, buttonRadius, buttonColors[i],
 		)
 	}
+
+	if c.title != "" {
+		title := utils.Truncate(c.title, (c.paddingRight()-titleMargin*2)/colWidth)
+		fmt.Fprintf(c.output, `<text x="%d" y="%d" style="text-anchor:middle;dominant-baseline:middle">%s</text>`,
+			c.paddingRight()/2, padding, escapeText(title))
+	}
 }
 
-func (c *canvas) addStyles() error {
-	styles := []string{}
-	for _, content := range c.stylesContent {
-		styles = append(styles, content)
+// flush writes the defs and styles created since the last flush.
+func (c *canvas) flush() error {
+	if len(c.defsPending) != 0 {
+		fmt.Fprintf(c.output, `<defs>`)
+		for _, d := range c.defsPending {
+			c.output.Write([]byte(d))
+		}
+		fmt.Fprintf(c.output, `</defs>`)
+		c.defsPending = c.defsPending[:0]
 	}
 
-	styles = append(styles,
-		fmt.Sprintf(`
-text {
-  font-family: Monaco,Consolas,Menlo,monospace;
-  font-size: 20px;
-  dominant-baseline: hanging;
-  text-anchor: start;
-  fill: %s;
+	if len(c.stylesPending) != 0 {
+		err := c.writeStyles(c.stylesPending)
+		if err != nil {
+			return err
+		}
+		c.stylesPending = c.stylesPending[:0]
+	}
+	return nil
 }
-`, c.getColor(vt10x.DefaultFG)),
-	)
 
-	styles = append(styles,
+func (c *canvas) writeStyles(styles []string) error {
+	s, err := minifyCSS(strings.Join(styles, ""))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.output, `<style>`)
+	c.output.Write([]byte(s))
+	fmt.Fprintf(c.output, `</style>`)
+	return nil
+}
+
+func (c *canvas) addAnimation() error {
+	var dur time.Duration
+	if len(c.offsets) != 0 {
+		dur = c.offsets[len(c.offsets)-1]
+	}
+
+	err := c.writeStyles([]string{
 		fmt.Sprintf(`
 #m {
   animation-duration: %.2fs;
@@ -180,31 +265,17 @@ text {
   animation-fill-mode: forwards;
 }
 `,
-			float64(c.offsets[len(c.offsets)-1])/float64(time.Second),
+			float64(dur)/float64(time.Second),
 			c.iterationCount,
 		),
-	)
-
-	styles = append(styles, generateKeyframes(c.offsets, int32(c.paddingRight())))
-
-	fmt.Fprintf(c.output, `<style>`)
-	defer fmt.Fprintf(c.output, `</style>`)
-
-	s, err := minifyCSS(strings.Join(styles, ""))
+	})
 	if err != nil {
 		return err
 	}
-	c.output.Write([]byte(s))
-	return nil
-}
 
-func (c *canvas) addDefs() error {
-	fmt.Fprintf(c.output, `<defs>`)
-	defer fmt.Fprintf(c.output, `</defs>`)
-
-	for _, d := range c.defsContent {
-		c.output.Write([]byte(d))
-	}
+	fmt.Fprintf(c.output, `<style>`)
+	writeKeyframes(c.output, c.offsets, int32(c.paddingRight()))
+	fmt.Fprintf(c.output, `</style>`)
 	return nil
 }
 
@@ -221,11 +292,15 @@ func (c *canvas) getDefs(unique string, f func(id string) string) string {
 	if ok {
 		return id
 	}
-	id = encodeIndex(uint64(len(c.defsContent)))
+	if len(c.defsIndex) >= maxDefs {
+		clear(c.defsIndex)
+	}
+	id = encodeIndex(c.defsCount)
+	c.defsCount++
 
 	c.defsIndex[unique] = id
 
-	c.defsContent = append(c.defsContent, f(id))
+	c.defsPending = append(c.defsPending, f(id))
 
 	return id
 }
@@ -238,11 +313,12 @@ func (c *canvas) getStyles(unique string, f func(id string) string) string {
 	if ok {
 		return id
 	}
-	id = encodeIndex(uint64(len(c.stylesContent)))
+	id = encodeIndex(c.stylesCount)
+	c.stylesCount++
 
 	c.stylesIndex[unique] = id
 
-	c.stylesContent = append(c.stylesContent, f(id))
+	c.stylesPending = append(c.stylesPending, f(id))
 
 	return id
 }
@@ -343,13 +419,18 @@ func (c *canvas) toGlyph(fg, bg vt10x.Color, mode vt10x.AttrFlag) []string {
 	return out
 }
 
-func generateKeyframes(offsets []time.Duration, width int32) string {
-	dur := offsets[len(offsets)-1]
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString("@keyframes k {")
+func writeKeyframes(w io.Writer, offsets []time.Duration, width int32) {
+	var dur time.Duration
+	if len(offsets) != 0 {
+		dur = offsets[len(offsets)-1]
+	}
+	fmt.Fprintf(w, "@keyframes k{")
 	for i, offset := range offsets {
-		fmt.Fprintf(buf, "%.3f%%{transform:translateX(-%dpx)}", float32(offset)*100/float32(dur), width*int32(i))
+		var percent float32
+		if dur != 0 {
+			percent = float32(offset) * 100 / float32(dur)
+		}
+		fmt.Fprintf(w, "%.3f%%{transform:translateX(-%dpx)}", percent, width*int32(i))
 	}
-	buf.WriteString("}")
-	return buf.String()
+	fmt.Fprintf(w, "}")
 }
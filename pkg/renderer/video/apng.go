@@ -0,0 +1,367 @@
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type apngEncoder struct {
+	output io.WriteSeeker
+	w      *bufio.Writer
+
+	width, height int
+
+	// actlOffset is where the animation control chunk is written,
+	// it is rewritten with the number of frames at the end.
+	actlOffset int64
+	written    int64
+
+	seq    uint32
+	frames uint32
+
+	prev    *image.NRGBA
+	pending *image.NRGBA
+
+	hasPending    bool
+	pendingOffset time.Duration
+
+	zbuf bytes.Buffer
+	zw   *zlib.Writer
+}
+
+// NewAPNGEncoder returns an encoder that writes an animated png to w.
+// Each frame after the first only contains the rectangle that changed.
+func NewAPNGEncoder(w io.WriteSeeker) Encoder {
+	e := &apngEncoder{
+		output: w,
+	}
+	e.w = bufio.NewWriter(writerFunc(func(p []byte) (int, error) {
+		n, err := e.output.Write(p)
+		e.written += int64(n)
+		return n, err
+	}))
+	return e
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (e *apngEncoder) Initialize(ctx context.Context, width, height int) error {
+	e.width = width
+	e.height = height
+	e.prev = image.NewNRGBA(image.Rect(0, 0, width, height))
+	e.pending = image.NewNRGBA(image.Rect(0, 0, width, height))
+	e.zw = zlib.NewWriter(&e.zbuf)
+
+	e.w.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8  // bit depth
+	ihdr[9] = 6  // truecolor with alpha
+	ihdr[10] = 0 // deflate
+	ihdr[11] = 0 // adaptive filtering
+	ihdr[12] = 0 // no interlace
+	err := e.writeChunk("IHDR", ihdr)
+	if err != nil {
+		return err
+	}
+
+	err = e.w.Flush()
+	if err != nil {
+		return err
+	}
+	e.actlOffset = e.written
+	return e.writeChunk("acTL", actl(0))
+}
+
+func actl(frames uint32) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[0:], frames)
+	binary.BigEndian.PutUint32(data[4:], 0) // loop forever
+	return data
+}
+
+func (e *apngEncoder) Encode(ctx context.Context, offset time.Duration, img image.Image) error {
+	if e.hasPending {
+		err := e.writeFrame(e.pending, offset-e.pendingOffset)
+		if err != nil {
+			return err
+		}
+		e.prev, e.pending = e.pending, e.prev
+	}
+
+	toNRGBA(e.pending, img)
+	e.pendingOffset = offset
+	e.hasPending = true
+	return nil
+}
+
+func (e *apngEncoder) Finish(ctx context.Context) error {
+	if e.hasPending {
+		err := e.writeFrame(e.pending, lastFrameDelay)
+		if err != nil {
+			return err
+		}
+		e.hasPending = false
+	}
+
+	err := e.writeChunk("IEND", nil)
+	if err != nil {
+		return err
+	}
+	err = e.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Now that the number of frames is known, rewrite the animation control chunk.
+	end := e.written
+	_, err = e.output.Seek(e.actlOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = e.writeChunk("acTL", actl(e.frames))
+	if err != nil {
+		return err
+	}
+	err = e.w.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = e.output.Seek(end, io.SeekStart)
+	return err
+}
+
+// toNRGBA copies img into dst, which has the same bounds.
+func toNRGBA(dst *image.NRGBA, img image.Image) {
+	b := dst.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+			out := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			for i := 0; i < b.Dx()*4; i += 4 {
+				r, g, bl, a := src[i], src[i+1], src[i+2], src[i+3]
+				switch a {
+				case 0:
+					r, g, bl = 0, 0, 0
+				case 0xff:
+				default:
+					r = uint8(uint16(r) * 0xff / uint16(a))
+					g = uint8(uint16(g) * 0xff / uint16(a))
+					bl = uint8(uint16(bl) * 0xff / uint16(a))
+				}
+				out[i], out[i+1], out[i+2], out[i+3] = r, g, bl, a
+			}
+		}
+		return
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.SetNRGBA(x, y, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+}
+
+// changedRect returns the bounds of the pixels that differ from the previous frame.
+func (e *apngEncoder) changedRect(frame *image.NRGBA) image.Rectangle {
+	if e.frames == 0 {
+		return frame.Bounds()
+	}
+	r := image.Rectangle{}
+	stride := e.width * 4
+	for y := 0; y < e.height; y++ {
+		cur := frame.Pix[y*frame.Stride : y*frame.Stride+stride]
+		prev := e.prev.Pix[y*e.prev.Stride : y*e.prev.Stride+stride]
+		if bytes.Equal(cur, prev) {
+			continue
+		}
+		minX, maxX := e.width, 0
+		for x := 0; x < e.width; x++ {
+			if !bytes.Equal(cur[x*4:x*4+4], prev[x*4:x*4+4]) {
+				minX = min(minX, x)
+				maxX = max(maxX, x+1)
+			}
+		}
+		r = r.Union(image.Rect(minX, y, maxX, y+1))
+	}
+	if r.Empty() {
+		// Keep a single pixel to carry the delay.
+		return image.Rect(0, 0, 1, 1)
+	}
+	return r
+}
+
+func (e *apngEncoder) writeFrame(frame *image.NRGBA, delay time.Duration) error {
+	rect := e.changedRect(frame)
+
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], e.seq)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(rect.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(rect.Dy()))
+	binary.BigEndian.PutUint32(fctl[12:], uint32(rect.Min.X))
+	binary.BigEndian.PutUint32(fctl[16:], uint32(rect.Min.Y))
+	num, den := apngDelay(delay)
+	binary.BigEndian.PutUint16(fctl[20:], num)
+	binary.BigEndian.PutUint16(fctl[22:], den)
+	fctl[24] = 0 // dispose none
+	fctl[25] = 0 // blend source
+	e.seq++
+	err := e.writeChunk("fcTL", fctl)
+	if err != nil {
+		return err
+	}
+
+	data, err := e.compress(frame, rect)
+	if err != nil {
+		return err
+	}
+
+	if e.frames == 0 {
+		err = e.writeChunk("IDAT", data)
+	} else {
+		fdat := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, e.seq)
+		copy(fdat[4:], data)
+		e.seq++
+		err = e.writeChunk("fdAT", fdat)
+	}
+	if err != nil {
+		return err
+	}
+	e.frames++
+	return nil
+}
+
+// apngDelay returns the delay as a fraction of seconds.
+func apngDelay(d time.Duration) (num, den uint16) {
+	ms := d.Milliseconds()
+	if ms <= 0xffff {
+		return uint16(max(ms, 0)), 1000
+	}
+	return uint16(min(ms/100, 0xffff)), 10
+}
+
+// compress returns the filtered and deflated rows of the rectangle.
+func (e *apngEncoder) compress(frame *image.NRGBA, rect image.Rectangle) ([]byte, error) {
+	e.zbuf.Reset()
+	e.zw.Reset(&e.zbuf)
+
+	n := rect.Dx() * 4
+	prior := make([]byte, n)
+	filtered := make([]byte, n+1)
+	best := make([]byte, n+1)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := frame.PixOffset(rect.Min.X, y)
+		row := frame.Pix[i : i+n]
+		filterRow(best, filtered, row, prior)
+		_, err := e.zw.Write(best)
+		if err != nil {
+			return nil, err
+		}
+		prior = row
+	}
+	err := e.zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return e.zbuf.Bytes(), nil
+}
+
+// filterRow picks the filter with the smallest sum of absolute values
+// for the row and writes the filter type and the filtered row to best.
+func filterRow(best, tmp, row, prior []byte) {
+	const bpp = 4
+	bestSum := -1
+	for ft := byte(0); ft <= 4; ft++ {
+		tmp[0] = ft
+		sum := 0
+		for i, x := range row {
+			var a, b, c byte
+			if i >= bpp {
+				a = row[i-bpp]
+				c = prior[i-bpp]
+			}
+			b = prior[i]
+			var v byte
+			switch ft {
+			case 0:
+				v = x
+			case 1:
+				v = x - a
+			case 2:
+				v = x - b
+			case 3:
+				v = x - byte((int(a)+int(b))/2)
+			case 4:
+				v = x - paeth(a, b, c)
+			}
+			tmp[i+1] = v
+			sum += abs8(v)
+		}
+		if bestSum < 0 || sum < bestSum {
+			bestSum = sum
+			copy(best, tmp)
+		}
+	}
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := absInt(p - int(a))
+	pb := absInt(p - int(b))
+	pc := absInt(p - int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs8(v byte) int {
+	if v < 128 {
+		return int(v)
+	}
+	return 256 - int(v)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (e *apngEncoder) writeChunk(typ string, data []byte) error {
+	if len(data) > 0x7fffffff {
+		return fmt.Errorf("png chunk %s is too large: %d bytes", typ, len(data))
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	e.w.Write(header[:])
+	e.w.Write(data)
+	_, err := e.w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
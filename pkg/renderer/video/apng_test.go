@@ -0,0 +1,207 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// readChunks walks the chunks of a png file and checks their crc.
+func readChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("missing png signature")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) != 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated chunk: %d bytes", len(data))
+		}
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			t.Fatalf("truncated chunk %q: %d bytes, want %d", data[4:8], len(data)-12, n)
+		}
+		typ := string(data[4:8])
+		crc := binary.BigEndian.Uint32(data[8+n:])
+		if want := crc32.ChecksumIEEE(data[4 : 8+n]); crc != want {
+			t.Fatalf("chunk %s: crc = %#x, want %#x", typ, crc, want)
+		}
+		chunks = append(chunks, pngChunk{typ: typ, data: data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return chunks
+}
+
+// decodeFrame decodes the image data of a frame as a standalone png.
+func decodeFrame(t *testing.T, width, height int, data []byte) image.Image {
+	t.Helper()
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // truecolor with alpha
+
+	buf := bytes.NewBuffer(slices.Clone(pngSignature))
+	for _, c := range []pngChunk{{"IHDR", ihdr}, {"IDAT", data}, {"IEND", nil}} {
+		binary.Write(buf, binary.BigEndian, uint32(len(c.data)))
+		buf.WriteString(c.typ)
+		buf.Write(c.data)
+		binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(c.typ), c.data...)))
+	}
+
+	img, err := png.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestAPNGEncoder(t *testing.T) {
+	ctx := context.Background()
+	frames := testFrames()
+
+	name := filepath.Join(t.TempDir(), "out.png")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	e := NewAPNGEncoder(f)
+	err = e.Initialize(ctx, 32, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		err = e.Encode(ctx, frame.offset, frame.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = e.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoders that do not know apng show the first frame.
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalImage(t, "default image", img, frames[0].img)
+
+	chunks := readChunks(t, data)
+	var types []string
+	for _, c := range chunks {
+		types = append(types, c.typ)
+	}
+	wantTypes := []string{
+		"IHDR", "acTL",
+		"fcTL", "IDAT",
+		"fcTL", "fdAT",
+		"fcTL", "fdAT",
+		"fcTL", "fdAT",
+		"IEND",
+	}
+	if !slices.Equal(types, wantTypes) {
+		t.Fatalf("chunks = %v, want %v", types, wantTypes)
+	}
+
+	// The number of frames is written once the encoder finishes.
+	actl := chunks[1].data
+	if n := binary.BigEndian.Uint32(actl[0:]); n != uint32(len(frames)) {
+		t.Errorf("acTL frames = %d, want %d", n, len(frames))
+	}
+	if plays := binary.BigEndian.Uint32(actl[4:]); plays != 0 {
+		t.Errorf("acTL plays = %d, want 0", plays)
+	}
+
+	wantDelays := [][2]uint16{{500, 1000}, {5, 1000}, {995, 1000}, {1000, 1000}}
+	wantRects := []image.Rectangle{
+		image.Rect(0, 0, 32, 24),
+		image.Rect(3, 4, 9, 7),
+		image.Rect(20, 10, 22, 20),
+		image.Rect(0, 0, 1, 1),
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	seq := uint32(0)
+	for i := range frames {
+		fctl := chunks[2+i*2].data
+		if got := binary.BigEndian.Uint32(fctl[0:]); got != seq {
+			t.Errorf("frame %d: fcTL sequence = %d, want %d", i, got, seq)
+		}
+		seq++
+		w := int(binary.BigEndian.Uint32(fctl[4:]))
+		h := int(binary.BigEndian.Uint32(fctl[8:]))
+		x := int(binary.BigEndian.Uint32(fctl[12:]))
+		y := int(binary.BigEndian.Uint32(fctl[16:]))
+		rect := image.Rect(x, y, x+w, y+h)
+		if rect != wantRects[i] {
+			t.Errorf("frame %d: rect = %v, want %v", i, rect, wantRects[i])
+		}
+		delay := [2]uint16{binary.BigEndian.Uint16(fctl[20:]), binary.BigEndian.Uint16(fctl[22:])}
+		if delay != wantDelays[i] {
+			t.Errorf("frame %d: delay = %d/%d, want %d/%d", i, delay[0], delay[1], wantDelays[i][0], wantDelays[i][1])
+		}
+
+		data := chunks[3+i*2].data
+		if i != 0 {
+			if got := binary.BigEndian.Uint32(data); got != seq {
+				t.Errorf("frame %d: fdAT sequence = %d, want %d", i, got, seq)
+			}
+			seq++
+			data = data[4:]
+		}
+
+		// The frames replace the rectangle they cover.
+		draw.Draw(canvas, rect, decodeFrame(t, w, h, data), image.Point{}, draw.Src)
+		equalImage(t, fmt.Sprintf("frame %d", i), canvas, frames[i].img)
+	}
+}
+
+func TestAPNGDelay(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		num, den uint16
+	}{
+		{0, 0, 1000},
+		{-time.Second, 0, 1000},
+		{1500 * time.Millisecond, 1500, 1000},
+		{65535 * time.Millisecond, 65535, 1000},
+		{70 * time.Second, 700, 10},
+		{time.Hour * 24, 0xffff, 10},
+	}
+	for _, tt := range tests {
+		num, den := apngDelay(tt.delay)
+		if num != tt.num || den != tt.den {
+			t.Errorf("apngDelay(%v) = %d/%d, want %d/%d", tt.delay, num, den, tt.num, tt.den)
+		}
+	}
+}
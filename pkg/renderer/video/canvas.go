@@ -2,16 +2,14 @@ package video
 
 import (
 	"context"
-	"fmt"
-	"image/png"
-	"io"
-	"os"
-	"path/filepath"
+	"image"
+	"sync"
 	"time"
 
 	"github.com/fogleman/gg"
 	"github.com/wzshiming/democtl/pkg/renderer"
 	"github.com/wzshiming/democtl/pkg/styles"
+	"github.com/wzshiming/democtl/pkg/utils"
 	"github.com/wzshiming/vt10x"
 	"golang.org/x/image/font"
 )
@@ -19,26 +17,59 @@ import (
 type canvas struct {
 	getColor func(i vt10x.Color) string
 	noWindow bool
+	title    string
 
-	regular       font.Face
-	regularItalic font.Face
-	bold          font.Face
-	boldItalic    font.Face
+	// mu guards the fonts and the glyphs, which are shared by the frames.
+	mu     sync.RWMutex
+	faces  [4]font.Face
+	glyphs map[glyphKey]*glyph
 
 	width, height int
 
-	frames io.WriteCloser
-	output string
+	scratchesMu sync.Mutex
+	scratches   []*scratch
 
-	lastOffset time.Duration
+	// next is the index of the next frame to encode,
+	// turn is closed and replaced every time it moves.
+	nextMu sync.Mutex
+	next   int
+	turn   chan struct{}
+
+	encoder Encoder
+}
+
+// scratch is an image the frames are drawn on, it keeps the rows of
+// the last frame drawn on it so only the rows that differ are drawn again.
+type scratch struct {
+	img  *image.RGBA
+	dc   *gg.Context
+	rows [][]byte
+}
+
+// Encoder writes the frames drawn by the canvas.
+type Encoder interface {
+	Initialize(ctx context.Context, width, height int) error
+	// Encode writes a frame that is shown from offset until the offset of the next frame,
+	// img is only valid during the call.
+	Encode(ctx context.Context, offset time.Duration, img image.Image) error
+	Finish(ctx context.Context) error
+}
+
+// AbortEncoder is implemented by encoders that must be stopped when the rendering fails.
+type AbortEncoder interface {
+	Encoder
+	Abort()
 }
 
 const (
-	rowHeight = 30
-	colWidth  = 12
-	padding   = 20
+	rowHeight   = 30
+	colWidth    = 12
+	padding     = 20
+	titleMargin = padding * 4
 )
 
+var buttonColors = [3]string{"#ff5f58", "#ffbd2e", "#18c132"}
+
 type Option func(*canvas)
 
 func WithWindows(b bool) Option {
@@ -47,17 +78,25 @@ func WithWindows(b bool) Option {
 	}
 }
 
+// WithTitle sets the title shown in the window bar.
+func WithTitle(title string) Option {
+	return func(c *canvas) {
+		c.title = title
+	}
+}
+
 func WithGetColor(getColor func(i vt10x.Color) string) Option {
 	return func(c *canvas) {
 		c.getColor = getColor
 	}
 }
 
-func NewCanvas(output string, options ...Option) renderer.Renderer {
+func NewCanvas(encoder Encoder, options ...Option) renderer.Renderer {
 	c := &canvas{
-		output:   output,
+		encoder:  encoder,
 		noWindow: false,
 		getColor: styles.Default().GetColorForHex,
+		turn:     make(chan struct{}),
 	}
 	for _, option := range options {
 		option(c)
@@ -68,63 +107,92 @@ func NewCanvas(output string, options ...Option) renderer.Renderer {
 func (c *canvas) Initialize(ctx context.Context, x, y int, width, height int) error {
 	c.width = width
 	c.height = height
-	frames, err := os.OpenFile(filepath.Join(c.output, "frames.txt"), os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-
-	c.frames = frames
-	return nil
+	return c.encoder.Initialize(ctx, c.paddingRight(), c.paddingBottom())
 }
 
 func (c *canvas) Finish(ctx context.Context) error {
-	return c.frames.Close()
+	return c.encoder.Finish(ctx)
 }
 
-func (c *canvas) Frame(ctx context.Context, index int, offset time.Duration) (renderer.Frame, error) {
-	width := c.paddingRight()
-	height := c.paddingBottom()
-	dc := gg.NewContext(width, height)
-	c.createWindow(dc)
+// Abort stops the encoder when the rendering failed.
+func (c *canvas) Abort() {
+	if a, ok := c.encoder.(AbortEncoder); ok {
+		a.Abort()
+	}
+}
+
+// Concurrent reports that the frames can be drawn at once,
+// they are still encoded in order.
+func (c *canvas) Concurrent() bool {
+	return true
+}
 
+func (c *canvas) Frame(ctx context.Context, index int, offset time.Duration) (renderer.Frame, error) {
 	return &frame{
 		canvas:    c,
-		dc:        dc,
+		ctx:       ctx,
+		index:     index,
 		offset:    offset,
 		heightOff: c.paddingTop(),
 		widthOff:  c.paddingLeft(),
-		finish: func() error {
-			imgName := fmt.Sprintf("frame%d.png", index)
-
-			if index != 0 {
-				_, err := fmt.Fprintf(c.frames, "duration %f\n", float64(offset-c.lastOffset)/float64(time.Second))
-				if err != nil {
-					return err
-				}
-			}
-
-			_, err := fmt.Fprintf(c.frames, "file '%s'\n", imgName)
-			if err != nil {
-				return err
-			}
-
-			c.lastOffset = offset
-
-			frame, err := os.OpenFile(filepath.Join(c.output, imgName), os.O_CREATE|os.O_WRONLY, 0600)
-			if err != nil {
-				return err
-			}
-			err = png.Encode(frame, dc.Image())
-			if err != nil {
-				return err
-			}
-			frame.Close()
+		texts:     make([][]textOp, c.height),
+		cursor:    image.Pt(-1, -1),
+	}, nil
+}
 
-			return nil
-		},
+func (c *canvas) getScratch() (*scratch, error) {
+	c.scratchesMu.Lock()
+	if n := len(c.scratches); n != 0 {
+		s := c.scratches[n-1]
+		c.scratches = c.scratches[:n-1]
+		c.scratchesMu.Unlock()
+		return s, nil
+	}
+	c.scratchesMu.Unlock()
+
+	dc := gg.NewContext(c.paddingRight(), c.paddingBottom())
+	err := c.createWindow(dc)
+	if err != nil {
+		return nil, err
+	}
+	return &scratch{
+		img:  dc.Image().(*image.RGBA),
+		dc:   dc,
+		rows: make([][]byte, c.height),
 	}, nil
 }
 
+func (c *canvas) putScratch(s *scratch) {
+	c.scratchesMu.Lock()
+	c.scratches = append(c.scratches, s)
+	c.scratchesMu.Unlock()
+}
+
+// waitTurn waits until the frames before index are encoded.
+func (c *canvas) waitTurn(ctx context.Context, index int) error {
+	for {
+		c.nextMu.Lock()
+		next, turn := c.next, c.turn
+		c.nextMu.Unlock()
+		if next == index {
+			return nil
+		}
+		select {
+		case <-turn:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+func (c *canvas) nextTurn() {
+	c.nextMu.Lock()
+	c.next++
+	close(c.turn)
+	c.turn = make(chan struct{})
+	c.nextMu.Unlock()
+}
+
 func (c *canvas) paddingLeft() int {
 	return padding
 }
@@ -144,17 +212,16 @@ func (c *canvas) paddingBottom() int {
 	return (c.height)*rowHeight + c.paddingTop()
 }
 
-func (c *canvas) createWindow(dc *gg.Context) {
+func (c *canvas) createWindow(dc *gg.Context) error {
 	bg := c.getColor(vt10x.DefaultBG)
 	if c.noWindow {
 		dc.SetHexColor(bg)
 		dc.Clear()
-		return
+		return nil
 	}
 
 	windowRadius := 5.0
 	buttonRadius := 7.0
-	buttonColors := [3]string{"#ff5f58", "#ffbd2e", "#18c132"}
 
 	dc.SetHexColor(bg)
 	dc.DrawRoundedRectangle(0, 0, float64(c.paddingRight()), float64(c.paddingBottom()), windowRadius)
@@ -167,4 +234,18 @@ func (c *canvas) createWindow(dc *gg.Context) {
 		dc.DrawCircle(x, y, buttonRadius)
 		dc.Fill()
 	}
+
+	if c.title != "" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		face, err := c.face(0)
+		if err != nil {
+			return err
+		}
+		title := utils.Truncate(c.title, (c.paddingRight()-titleMargin*2)/colWidth)
+		dc.SetFontFace(face)
+		dc.SetHexColor(c.getColor(vt10x.DefaultFG))
+		dc.DrawStringAnchored(title, float64(c.paddingRight())/2, padding, 0.5, 0.35)
+	}
+	return nil
 }
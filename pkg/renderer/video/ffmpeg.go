@@ -0,0 +1,167 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os/exec"
+	"time"
+)
+
+// ivfTimeBase is the time base of the frame timestamps, in milliseconds.
+const ivfTimeBase = 1000
+
+type ffmpegEncoder struct {
+	path   string
+	output string
+	args   []string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+
+	png *png.Encoder
+	buf bytes.Buffer
+
+	// last is the last frame written and lastPTS its timestamp,
+	// the last frame is written again to give it a duration.
+	last    []byte
+	lastPTS int64
+	written bool
+}
+
+// NewFFmpegEncoder returns an encoder that pipes the frames to ffmpeg as png images in an ivf stream,
+// each frame is written once with its timestamp and lasts until the next one.
+// The args are passed to ffmpeg before the output, e.g. the codec and pixel format.
+func NewFFmpegEncoder(path, output string, args []string) Encoder {
+	return &ffmpegEncoder{
+		path:   path,
+		output: output,
+		args:   args,
+		png: &png.Encoder{
+			CompressionLevel: png.BestSpeed,
+		},
+	}
+}
+
+func (e *ffmpegEncoder) Initialize(ctx context.Context, width, height int) error {
+	if width > math.MaxUint16 || height > math.MaxUint16 {
+		return fmt.Errorf("frame size %dx%d is too large", width, height)
+	}
+
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-y",
+		"-f", "ivf",
+		"-i", "-",
+		"-fps_mode", "vfr",
+	}
+	args = append(args, e.args...)
+	args = append(args, e.output)
+
+	// The process is killed when the context is canceled.
+	e.cmd = exec.CommandContext(ctx, e.path, args...)
+	e.cmd.Stderr = &e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	e.stdin = stdin
+
+	err = e.cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	// The header of the ivf stream, the frame count is unknown.
+	var header [32]byte
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)
+	binary.LittleEndian.PutUint16(header[6:], uint16(len(header)))
+	copy(header[8:], "MPNG")
+	binary.LittleEndian.PutUint16(header[12:], uint16(width))
+	binary.LittleEndian.PutUint16(header[14:], uint16(height))
+	binary.LittleEndian.PutUint32(header[16:], ivfTimeBase)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	_, err = e.stdin.Write(header[:])
+	if err != nil {
+		return e.wrapError(err)
+	}
+	return nil
+}
+
+func (e *ffmpegEncoder) Encode(ctx context.Context, offset time.Duration, img image.Image) error {
+	e.buf.Reset()
+	err := e.png.Encode(&e.buf, img)
+	if err != nil {
+		return err
+	}
+	e.last = append(e.last[:0], e.buf.Bytes()...)
+	return e.writeFrame(offset)
+}
+
+// writeFrame writes the last frame with the timestamp of offset,
+// which is moved after the previous one when they would be equal.
+func (e *ffmpegEncoder) writeFrame(offset time.Duration) error {
+	pts := offset.Milliseconds()
+	if e.written && pts <= e.lastPTS {
+		pts = e.lastPTS + 1
+	}
+
+	var header [12]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(e.last)))
+	binary.LittleEndian.PutUint64(header[4:], uint64(pts))
+	_, err := e.stdin.Write(header[:])
+	if err != nil {
+		return e.wrapError(err)
+	}
+	_, err = e.stdin.Write(e.last)
+	if err != nil {
+		return e.wrapError(err)
+	}
+	e.lastPTS = pts
+	e.written = true
+	return nil
+}
+
+func (e *ffmpegEncoder) Finish(ctx context.Context) error {
+	if e.written {
+		err := e.writeFrame(time.Duration(e.lastPTS)*time.Millisecond + lastFrameDelay)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := e.stdin.Close()
+	if err != nil {
+		return e.wrapError(err)
+	}
+	err = e.cmd.Wait()
+	if err != nil {
+		return e.wrapError(err)
+	}
+	return nil
+}
+
+// Abort kills ffmpeg and waits for it to exit, so the output is no longer written.
+func (e *ffmpegEncoder) Abort() {
+	if e.cmd == nil || e.cmd.Process == nil || e.cmd.ProcessState != nil {
+		return
+	}
+	_ = e.stdin.Close()
+	_ = e.cmd.Process.Kill()
+	_ = e.cmd.Wait()
+}
+
+func (e *ffmpegEncoder) wrapError(err error) error {
+	if e.stderr.Len() == 0 {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(e.stderr.Bytes()))
+}
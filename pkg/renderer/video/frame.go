@@ -1,11 +1,12 @@
 package video
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"image"
 	"time"
 
-	"github.com/fogleman/gg"
-	"github.com/wzshiming/democtl/pkg/fonts"
 	"github.com/wzshiming/democtl/pkg/styles"
 	"github.com/wzshiming/democtl/pkg/utils"
 	"github.com/wzshiming/vt10x"
@@ -13,19 +14,25 @@ import (
 
 type frame struct {
 	*canvas
+	*scratch
 
-	dc *gg.Context
+	ctx context.Context
 
+	index  int
 	offset time.Duration
 
 	heightOff, widthOff int
 
-	finish func() error
+	// texts are the texts of each row, drawn when the frame is finished.
+	texts  [][]textOp
+	cursor image.Point
 }
 
-var opt = fonts.Options{
-	Size: 20,
-	DPI:  72,
+type textOp struct {
+	x      int
+	text   string
+	fg, bg vt10x.Color
+	mode   vt10x.AttrFlag
 }
 
 func (f *frame) offsetX(x int) float64 {
@@ -36,49 +43,107 @@ func (f *frame) offsetY(y int) float64 {
 	return float64(f.heightOff + y*rowHeight)
 }
 
-func (f *frame) setFont(mode vt10x.AttrFlag) error {
-	var err error
-	if mode&vt10x.AttrBold != 0 {
-		if mode&vt10x.AttrItalic != 0 {
-			if f.boldItalic == nil {
-				f.boldItalic, err = fonts.LoadFontFace(fonts.BoldItalic, opt)
-				if err != nil {
-					return err
-				}
-			}
-			f.dc.SetFontFace(f.boldItalic)
-		} else {
-			if f.bold == nil {
-				f.bold, err = fonts.LoadFontFace(fonts.Bold, opt)
-				if err != nil {
-					return err
-				}
-			}
-			f.dc.SetFontFace(f.bold)
+// rowTop returns the top of the band of pixels owned by the row.
+func (f *frame) rowTop(y int) int {
+	return f.heightOff + y*rowHeight - rowHeight + 7
+}
+
+func (f *frame) DrawText(ctx context.Context, x, y int, text string, fg, bg vt10x.Color, mode vt10x.AttrFlag) error {
+	f.texts[y] = append(f.texts[y], textOp{x: x, text: text, fg: fg, bg: bg, mode: mode})
+	return nil
+}
+
+func (f *frame) DrawCursor(ctx context.Context, x, y int) error {
+	f.cursor = image.Pt(x, y)
+	return nil
+}
+
+// appendRow appends everything that is drawn in the band of the row to buf.
+// The cursor overflows into the band of the next row.
+func (f *frame) appendRow(buf []byte, y int) []byte {
+	// Start with a byte, a nil row is a row that was never drawn.
+	buf = append(buf, 0)
+	for _, t := range f.texts[y] {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(t.x))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(t.fg))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(t.bg))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(t.mode))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(t.text)))
+		buf = append(buf, t.text...)
+	}
+	if f.cursor.Y == y {
+		buf = append(buf, 1)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(f.cursor.X))
+	}
+	if f.cursor.Y == y-1 {
+		buf = append(buf, 2)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(f.cursor.X))
+	}
+	return buf
+}
+
+func (f *frame) Finish(ctx context.Context) error {
+	s, err := f.getScratch()
+	if err != nil {
+		return err
+	}
+	defer f.putScratch(s)
+	f.scratch = s
+
+	err = f.draw()
+	if err != nil {
+		return err
+	}
+
+	err = f.waitTurn(ctx, f.index)
+	if err != nil {
+		return err
+	}
+	defer f.nextTurn()
+	return f.encoder.Encode(f.ctx, f.offset, f.img)
+}
+
+func (f *frame) draw() error {
+	// The rows are compared with the ones last drawn on the scratch,
+	// row keeps the content of a row until it replaces the one that differs.
+	dirty := make([]bool, len(f.texts))
+	var row []byte
+	for y := range f.texts {
+		row = f.appendRow(row[:0], y)
+		if !bytes.Equal(f.rows[y], row) {
+			f.rows[y], row = row, f.rows[y]
+			dirty[y] = true
 		}
-	} else {
-		if mode&vt10x.AttrItalic != 0 {
-			if f.regularItalic == nil {
-				f.regularItalic, err = fonts.LoadFontFace(fonts.RegularItalic, opt)
-				if err != nil {
-					return err
-				}
-			}
-			f.dc.SetFontFace(f.regularItalic)
-		} else {
-			if f.regular == nil {
-				f.regular, err = fonts.LoadFontFace(fonts.Regular, opt)
-				if err != nil {
-					return err
-				}
+	}
+
+	bg := f.getColor(vt10x.DefaultBG)
+	f.dc.SetHexColor(bg)
+	for y, d := range dirty {
+		if d {
+			f.dc.DrawRectangle(0, float64(f.rowTop(y)), float64(f.paddingRight()), rowHeight)
+			f.dc.Fill()
+		}
+	}
+
+	for y, d := range dirty {
+		if !d {
+			continue
+		}
+		for _, t := range f.texts[y] {
+			err := f.drawText(t.x, y, t.text, t.fg, t.bg, t.mode)
+			if err != nil {
+				return err
 			}
-			f.dc.SetFontFace(f.regular)
 		}
 	}
+
+	if f.cursor.Y >= 0 {
+		f.drawCursor(f.cursor.X, f.cursor.Y, dirty)
+	}
 	return nil
 }
 
-func (f *frame) DrawText(ctx context.Context, x, y int, text string, fg, bg vt10x.Color, mode vt10x.AttrFlag) error {
+func (f *frame) drawText(x, y int, text string, fg, bg vt10x.Color, mode vt10x.AttrFlag) error {
 	if mode&vt10x.AttrReverse != 0 {
 		fg, bg = bg, fg
 	}
@@ -104,19 +169,17 @@ func (f *frame) DrawText(ctx context.Context, x, y int, text string, fg, bg vt10
 		return nil
 	}
 
-	err := f.setFont(mode)
-	if err != nil {
-		return err
-	}
-
-	f.dc.SetHexColor(colorStr)
+	face := faceIndex(mode)
+	top := f.rowTop(y)
 	for i, r := range text {
-		err := f.drawText(ctx, x+i, y, r, fg, bg, mode)
+		g, err := f.glyph(r, face, colorStr)
 		if err != nil {
 			return err
 		}
+		drawGlyph(f.img, g, int(f.offsetX(x+i)), top)
 	}
 
+	f.dc.SetHexColor(colorStr)
 	if mode&vt10x.AttrUnderline != 0 {
 		f.dc.DrawLine(offsetX, offsetY+5, offsetX+width*colWidth, offsetY+5)
 		f.dc.Stroke()
@@ -128,33 +191,25 @@ func (f *frame) DrawText(ctx context.Context, x, y int, text string, fg, bg vt10
 	return nil
 }
 
-func (f *frame) drawText(ctx context.Context, x, y int, text rune, fg, bg vt10x.Color, mode vt10x.AttrFlag) error {
-	offsetX := f.offsetX(x)
-	offsetY := f.offsetY(y)
-
-	f.dc.DrawStringAnchored(string(text), offsetX, offsetY, 0, 0)
-	return nil
-}
-
-func (f *frame) DrawCursor(ctx context.Context, x, y int) error {
+// drawCursor draws the part of the cursor that falls in the dirty rows,
+// the other rows still have the cursor of the previous frame.
+func (f *frame) drawCursor(x, y int, dirty []bool) {
 	offsetX := f.offsetX(x)
 	offsetY := f.offsetY(y)
 
 	f.dc.SetHexColor(f.getColor(vt10x.DefaultCursor) + "aa")
-	f.dc.DrawRectangle(
-		offsetX+3, offsetY-20,
-		colWidth, rowHeight)
-	f.dc.Fill()
-	return nil
-}
-
-func (f *frame) Finish(ctx context.Context) error {
-	if f.finish == nil {
-		return nil
-	}
-	err := f.finish()
-	if err != nil {
-		return err
+	top := offsetY - 20
+	bottom := top + rowHeight
+	for _, row := range [2]int{y, y + 1} {
+		if row >= len(dirty) || !dirty[row] {
+			continue
+		}
+		bandTop := float64(f.rowTop(row))
+		t := max(top, bandTop)
+		b := min(bottom, bandTop+rowHeight)
+		if t < b {
+			f.dc.DrawRectangle(offsetX+3, t, colWidth, b-t)
+			f.dc.Fill()
+		}
 	}
-	return nil
 }
@@ -0,0 +1,267 @@
+package video
+
+import (
+	"bufio"
+	"compress/lzw"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+const (
+	// gifMinDelay is the shortest delay in centiseconds that browsers honor,
+	// shorter frames are merged into the next one.
+	gifMinDelay = 2
+
+	// lastFrameDelay is how long the last frame is shown before looping.
+	lastFrameDelay = time.Second
+
+	gifDisposalNone = 1 << 2
+)
+
+type gifEncoder struct {
+	w       *bufio.Writer
+	palette color.Palette
+	lookup  map[color.RGBA]uint8
+
+	width, height int
+	litWidth      int
+
+	prev    []uint8
+	pending []uint8
+	scratch []uint8
+
+	hasPending    bool
+	pendingOffset time.Duration
+	index         int
+}
+
+// NewGIFEncoder returns an encoder that writes an animated gif to w.
+// Each frame after the first only contains the rectangle that changed,
+// with the unchanged pixels left transparent.
+func NewGIFEncoder(w io.Writer, palette color.Palette) Encoder {
+	return &gifEncoder{
+		w:       bufio.NewWriter(w),
+		palette: palette,
+		lookup:  map[color.RGBA]uint8{},
+	}
+}
+
+func (e *gifEncoder) Initialize(ctx context.Context, width, height int) error {
+	if len(e.palette) > 256 {
+		return fmt.Errorf("palette has %d colors: expected at most 256", len(e.palette))
+	}
+	if width > 0xffff || height > 0xffff {
+		return fmt.Errorf("image is too large for gif: %dx%d", width, height)
+	}
+	e.width = width
+	e.height = height
+	e.prev = make([]uint8, width*height)
+	e.pending = make([]uint8, width*height)
+	e.scratch = make([]uint8, width*height)
+
+	bits := 1
+	for 1<<bits < len(e.palette) {
+		bits++
+	}
+	e.litWidth = max(bits, 2)
+
+	e.w.WriteString("GIF89a")
+	binary.Write(e.w, binary.LittleEndian, [2]uint16{uint16(width), uint16(height)})
+	e.w.Write([]byte{0x80 | 0x70 | byte(bits-1), 0, 0})
+	for i := 0; i < 1<<bits; i++ {
+		var rgb [3]byte
+		if i < len(e.palette) {
+			r, g, b, _ := e.palette[i].RGBA()
+			rgb = [3]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)}
+		}
+		e.w.Write(rgb[:])
+	}
+
+	// Loop forever.
+	e.w.Write([]byte{0x21, 0xff, 0x0b})
+	e.w.WriteString("NETSCAPE2.0")
+	_, err := e.w.Write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+	return err
+}
+
+func (e *gifEncoder) Encode(ctx context.Context, offset time.Duration, img image.Image) error {
+	e.quantize(img, e.scratch)
+
+	if e.hasPending {
+		delay := centiseconds(offset) - centiseconds(e.pendingOffset)
+		if delay < gifMinDelay {
+			e.pending, e.scratch = e.scratch, e.pending
+			return nil
+		}
+		err := e.writeFrame(e.pending, delay)
+		if err != nil {
+			return err
+		}
+		e.prev, e.pending = e.pending, e.prev
+	}
+
+	e.pending, e.scratch = e.scratch, e.pending
+	e.pendingOffset = offset
+	e.hasPending = true
+	return nil
+}
+
+func (e *gifEncoder) Finish(ctx context.Context) error {
+	if e.hasPending {
+		err := e.writeFrame(e.pending, centiseconds(lastFrameDelay))
+		if err != nil {
+			return err
+		}
+		e.hasPending = false
+	}
+	e.w.WriteByte(0x3b)
+	return e.w.Flush()
+}
+
+func centiseconds(d time.Duration) int {
+	return int((d + 5*time.Millisecond) / (10 * time.Millisecond))
+}
+
+// quantize maps the pixels of img to the nearest colors of the palette.
+func (e *gifEncoder) quantize(img image.Image, dst []uint8) {
+	rgba, ok := img.(*image.RGBA)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			var c color.RGBA
+			if ok {
+				i := rgba.PixOffset(x, y)
+				p := rgba.Pix[i : i+4 : i+4]
+				c = color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+			} else {
+				c = color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			}
+			if c.A == 0 {
+				dst[y*e.width+x] = 0
+				continue
+			}
+			index, ok := e.lookup[c]
+			if !ok {
+				index = uint8(e.palette.Index(c))
+				e.lookup[c] = index
+			}
+			dst[y*e.width+x] = index
+		}
+	}
+}
+
+// changedRect returns the bounds of the pixels that differ from the previous frame.
+func (e *gifEncoder) changedRect(frame []uint8) image.Rectangle {
+	if e.index == 0 {
+		return image.Rect(0, 0, e.width, e.height)
+	}
+	r := image.Rectangle{}
+	for y := 0; y < e.height; y++ {
+		row := y * e.width
+		for x := 0; x < e.width; x++ {
+			if frame[row+x] != e.prev[row+x] {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if r.Empty() {
+		// Keep a single transparent pixel to carry the delay.
+		return image.Rect(0, 0, 1, 1)
+	}
+	return r
+}
+
+func (e *gifEncoder) writeFrame(frame []uint8, delay int) error {
+	rect := e.changedRect(frame)
+	delay = min(delay, 0xffff)
+
+	// Graphic control extension, index 0 is transparent.
+	e.w.Write([]byte{0x21, 0xf9, 0x04, gifDisposalNone | 0x01})
+	binary.Write(e.w, binary.LittleEndian, uint16(delay))
+	e.w.Write([]byte{0x00, 0x00})
+
+	// Image descriptor.
+	e.w.WriteByte(0x2c)
+	binary.Write(e.w, binary.LittleEndian, [4]uint16{
+		uint16(rect.Min.X), uint16(rect.Min.Y),
+		uint16(rect.Dx()), uint16(rect.Dy()),
+	})
+	e.w.WriteByte(0x00)
+
+	e.w.WriteByte(byte(e.litWidth))
+	bw := &blockWriter{w: e.w}
+	lw := lzw.NewWriter(bw, lzw.LSB, e.litWidth)
+	row := make([]uint8, rect.Dx())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := y*e.width + x
+			if e.index != 0 && frame[i] == e.prev[i] {
+				row[x-rect.Min.X] = 0
+			} else {
+				row[x-rect.Min.X] = frame[i]
+			}
+		}
+		_, err := lw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	err := lw.Close()
+	if err != nil {
+		return err
+	}
+	err = bw.Close()
+	if err != nil {
+		return err
+	}
+	e.index++
+	return nil
+}
+
+// blockWriter splits the data into the length prefixed sub-blocks of gif.
+type blockWriter struct {
+	w   io.Writer
+	buf [256]byte
+	n   int
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) != 0 {
+		n := copy(b.buf[1+b.n:], p)
+		b.n += n
+		p = p[n:]
+		written += n
+		if b.n == 255 {
+			err := b.flush()
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (b *blockWriter) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	b.buf[0] = byte(b.n)
+	_, err := b.w.Write(b.buf[:1+b.n])
+	b.n = 0
+	return err
+}
+
+// Close writes the remaining data and the block terminator.
+func (b *blockWriter) Close() error {
+	err := b.flush()
+	if err != nil {
+		return err
+	}
+	_, err = b.w.Write([]byte{0x00})
+	return err
+}
@@ -0,0 +1,202 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	testRed   = color.RGBA{R: 0xff, A: 0xff}
+	testGreen = color.RGBA{G: 0xff, A: 0xff}
+	testBlue  = color.RGBA{B: 0xff, A: 0xff}
+	testWhite = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+	testPalette = color.Palette{color.RGBA{}, testRed, testGreen, testBlue, testWhite}
+)
+
+type testFrame struct {
+	offset time.Duration
+	img    *image.RGBA
+}
+
+// testFrames returns noise of red, green and blue, then white rectangles
+// drawn over it, the third frame comes 5ms after the second and the last
+// frame is the same as the third.
+func testFrames() []testFrame {
+	const width, height = 32, 24
+	noise := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			seed = seed*1664525 + 1013904223
+			noise.SetRGBA(x, y, testPalette[1+(seed>>16)%3].(color.RGBA))
+		}
+	}
+
+	fill := func(img *image.RGBA, r image.Rectangle) *image.RGBA {
+		out := image.NewRGBA(img.Bounds())
+		copy(out.Pix, img.Pix)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				out.SetRGBA(x, y, testWhite)
+			}
+		}
+		return out
+	}
+	first := fill(noise, image.Rect(3, 4, 9, 7))
+	second := fill(first, image.Rect(20, 10, 22, 20))
+
+	return []testFrame{
+		{offset: 0, img: noise},
+		{offset: 500 * time.Millisecond, img: first},
+		{offset: 505 * time.Millisecond, img: second},
+		{offset: 1500 * time.Millisecond, img: second},
+	}
+}
+
+// equalImage reports the first pixel of got that differs from want.
+func equalImage(t *testing.T, name string, got image.Image, want *image.RGBA) {
+	t.Helper()
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			w := want.RGBAAt(x, y)
+			if g != w {
+				t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestGIFEncoder(t *testing.T) {
+	ctx := context.Background()
+	frames := testFrames()
+
+	var buf bytes.Buffer
+	e := NewGIFEncoder(&buf, testPalette)
+	err := e.Initialize(ctx, 32, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		err = e.Encode(ctx, f.offset, f.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = e.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.LoopCount != 0 {
+		t.Errorf("loop count = %d, want 0", g.LoopCount)
+	}
+	if g.Config.Width != 32 || g.Config.Height != 24 {
+		t.Errorf("size = %dx%d, want 32x24", g.Config.Width, g.Config.Height)
+	}
+
+	// The frame 5ms after the second one is merged into it.
+	wantDelays := []int{50, 100, 100}
+	wantRects := []image.Rectangle{
+		image.Rect(0, 0, 32, 24),
+		image.Rect(3, 4, 22, 20),
+		image.Rect(0, 0, 1, 1),
+	}
+	wantImages := []*image.RGBA{frames[0].img, frames[2].img, frames[3].img}
+	if len(g.Image) != len(wantDelays) {
+		t.Fatalf("got %d frames, want %d", len(g.Image), len(wantDelays))
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for i, frame := range g.Image {
+		if g.Delay[i] != wantDelays[i] {
+			t.Errorf("frame %d: delay = %d, want %d", i, g.Delay[i], wantDelays[i])
+		}
+		if g.Disposal[i] != gif.DisposalNone {
+			t.Errorf("frame %d: disposal = %d, want %d", i, g.Disposal[i], gif.DisposalNone)
+		}
+		if frame.Rect != wantRects[i] {
+			t.Errorf("frame %d: rect = %v, want %v", i, frame.Rect, wantRects[i])
+		}
+
+		// Index 0 is transparent and leaves the previous frame visible.
+		b := frame.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				index := frame.ColorIndexAt(x, y)
+				if index == 0 {
+					continue
+				}
+				canvas.Set(x, y, frame.Palette[index])
+			}
+		}
+		equalImage(t, fmt.Sprintf("frame %d", i), canvas, wantImages[i])
+	}
+}
+
+func TestGIFEncoderTooManyColors(t *testing.T) {
+	palette := make(color.Palette, 257)
+	for i := range palette {
+		palette[i] = color.RGBA{}
+	}
+	e := NewGIFEncoder(&bytes.Buffer{}, palette)
+	err := e.Initialize(context.Background(), 1, 1)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBlockWriter(t *testing.T) {
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	bw := &blockWriter{w: &buf}
+	_, err := bw.Write(data[:100])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bw.Write(data[100:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	var sizes []int
+	out := buf.Bytes()
+	for out[0] != 0 {
+		n := int(out[0])
+		sizes = append(sizes, n)
+		got = append(got, out[1:1+n]...)
+		out = out[1+n:]
+	}
+	if len(out) != 1 {
+		t.Errorf("%d bytes after the terminator", len(out)-1)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("data of the sub-blocks differs")
+	}
+	wantSizes := []int{255, 255, 90}
+	if !slices.Equal(sizes, wantSizes) {
+		t.Errorf("sizes = %v, want %v", sizes, wantSizes)
+	}
+}
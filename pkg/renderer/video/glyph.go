@@ -0,0 +1,129 @@
+package video
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/fogleman/gg"
+	"github.com/wzshiming/democtl/pkg/fonts"
+	"github.com/wzshiming/vt10x"
+	"golang.org/x/image/font"
+)
+
+var opt = fonts.Options{
+	Size: 20,
+	DPI:  72,
+}
+
+var faceData = [4][]byte{
+	fonts.Regular,
+	fonts.RegularItalic,
+	fonts.Bold,
+	fonts.BoldItalic,
+}
+
+// faceIndex returns the index of the font face for the mode.
+func faceIndex(mode vt10x.AttrFlag) int {
+	i := 0
+	if mode&vt10x.AttrItalic != 0 {
+		i |= 1
+	}
+	if mode&vt10x.AttrBold != 0 {
+		i |= 2
+	}
+	return i
+}
+
+// face returns the font face, c.mu must be held.
+func (c *canvas) face(i int) (font.Face, error) {
+	if c.faces[i] == nil {
+		face, err := fonts.LoadFontFace(faceData[i], opt)
+		if err != nil {
+			return nil, err
+		}
+		c.faces[i] = face
+	}
+	return c.faces[i], nil
+}
+
+type glyphKey struct {
+	r     rune
+	face  int
+	color string
+}
+
+// glyph is a rasterized rune, placed relative to the top left of its cell.
+type glyph struct {
+	img    *image.RGBA
+	offset image.Point
+}
+
+const (
+	// glyphMargin is the room left around the cell for the parts of
+	// the glyph that overflow it, such as italic slants.
+	glyphMargin = colWidth
+	// glyphBaseline is the baseline of the glyph from the top of the cell.
+	glyphBaseline = rowHeight - 7
+)
+
+// glyph returns the cached bitmap of the rune, rasterizing it on the first use.
+func (c *canvas) glyph(r rune, face int, color string) (*glyph, error) {
+	key := glyphKey{r: r, face: face, color: color}
+	c.mu.RLock()
+	g, ok := c.glyphs[key]
+	c.mu.RUnlock()
+	if ok {
+		return g, nil
+	}
+
+	// The font faces are not safe for concurrent use.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, ok := c.glyphs[key]; ok {
+		return g, nil
+	}
+
+	f, err := c.face(face)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := gg.NewContext(glyphMargin*2+colWidth*2, rowHeight)
+	dc.SetFontFace(f)
+	dc.SetHexColor(color)
+	dc.DrawStringAnchored(string(r), glyphMargin, glyphBaseline, 0, 0)
+	img := dc.Image().(*image.RGBA)
+
+	bounds := opaqueBounds(img)
+	g = &glyph{
+		img:    image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy())),
+		offset: bounds.Min.Sub(image.Pt(glyphMargin, 0)),
+	}
+	draw.Draw(g.img, g.img.Bounds(), img, bounds.Min, draw.Src)
+
+	if c.glyphs == nil {
+		c.glyphs = map[glyphKey]*glyph{}
+	}
+	c.glyphs[key] = g
+	return g, nil
+}
+
+// opaqueBounds returns the smallest rectangle that contains all the visible pixels.
+func opaqueBounds(img *image.RGBA) image.Rectangle {
+	r := image.Rectangle{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// drawGlyph draws the glyph in the cell whose top left corner is at x, y.
+func drawGlyph(dst *image.RGBA, g *glyph, x, y int) {
+	min := image.Pt(x, y).Add(g.offset)
+	draw.Draw(dst, g.img.Bounds().Add(min), g.img, image.Point{}, draw.Over)
+}
@@ -0,0 +1,84 @@
+package video
+
+import (
+	"image/color"
+
+	"github.com/wzshiming/democtl/pkg/styles"
+	"github.com/wzshiming/vt10x"
+)
+
+// rampSteps is the number of colors between the background and
+// each color, used by the anti-aliased edges of the text.
+const rampSteps = 3
+
+// NewPalette returns a palette of at most 256 colors for the styles,
+// the first color is transparent.
+func NewPalette(getColor func(i vt10x.Color) string) color.Palette {
+	bg := getColor(vt10x.DefaultBG)
+
+	base := []string{
+		bg,
+		getColor(vt10x.DefaultFG),
+		getColor(vt10x.DefaultCursor),
+	}
+	for i := vt10x.Color(0); i < 16; i++ {
+		base = append(base, getColor(i))
+	}
+	dims := []string{}
+	for _, c := range base[1:] {
+		r, g, b := styles.ParseHexColor(c)
+		dims = append(dims, styles.FormatHexColor(r/2, g/2, b/2))
+	}
+	base = append(base, dims...)
+	base = append(base, buttonColors[:]...)
+
+	p := color.Palette{color.RGBA{}}
+	seen := map[color.RGBA]bool{{}: true}
+	add := func(c color.RGBA) {
+		if len(p) == 256 || seen[c] {
+			return
+		}
+		seen[c] = true
+		p = append(p, c)
+	}
+
+	for _, c := range base {
+		add(hexToRGBA(c))
+	}
+
+	bgColor := hexToRGBA(bg)
+	for _, c := range base[1:] {
+		fgColor := hexToRGBA(c)
+		for i := 1; i <= rampSteps; i++ {
+			add(mixRGBA(bgColor, fgColor, float64(i)/(rampSteps+1)))
+		}
+	}
+
+	// A coarse color cube for the true colors used by the programs.
+	levels := [...]uint8{0x00, 0x55, 0xaa, 0xff}
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				add(color.RGBA{R: r, G: g, B: b, A: 0xff})
+			}
+		}
+	}
+	return p
+}
+
+func hexToRGBA(x string) color.RGBA {
+	r, g, b := styles.ParseHexColor(x)
+	return color.RGBA{R: r, G: g, B: b, A: 0xff}
+}
+
+func mixRGBA(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x)*(1-t) + float64(y)*t + 0.5)
+	}
+	return color.RGBA{
+		R: mix(a.R, b.R),
+		G: mix(a.G, b.G),
+		B: mix(a.B, b.B),
+		A: 0xff,
+	}
+}
@@ -0,0 +1,68 @@
+package renderer
+
+import (
+	"context"
+	"sync"
+)
+
+type job struct {
+	screen        screen
+	width, height int
+	frame         Frame
+}
+
+// workers draw the frames of a ConcurrentRenderer on several goroutines,
+// the first error stops all of them.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	jobs chan job
+	wg   sync.WaitGroup
+}
+
+func newWorkers(ctx context.Context, n int) *workers {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &workers{
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(chan job, n),
+	}
+	w.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go w.run()
+	}
+	return w
+}
+
+func (w *workers) run() {
+	defer w.wg.Done()
+	for j := range w.jobs {
+		if w.ctx.Err() != nil {
+			continue
+		}
+		err := frame(w.ctx, j.screen, j.width, j.height, j.frame)
+		if err != nil {
+			w.cancel(err)
+		}
+	}
+}
+
+// draw queues the frame, it blocks while all the workers are busy.
+func (w *workers) draw(s screen, width, height int, f Frame) error {
+	select {
+	case w.jobs <- job{screen: s, width: width, height: height, frame: f}:
+		return nil
+	case <-w.ctx.Done():
+		return context.Cause(w.ctx)
+	}
+}
+
+// wait waits for the queued frames and returns the first error.
+func (w *workers) wait() error {
+	close(w.jobs)
+	w.wg.Wait()
+	err := context.Cause(w.ctx)
+	w.cancel(nil)
+	return err
+}
@@ -25,7 +25,14 @@ func Replay(ctx context.Context, input io.Reader) error {
 			}
 			return err
 		}
-		time.Sleep(time.Duration((event.Time - lastTime) * float64(time.Second)))
+		if event.Type != cast.OutputEvent {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration((event.Time - lastTime) * float64(time.Second))):
+		}
 		lastTime = event.Time
 
 		_, err = os.Stdout.WriteString(event.Data)
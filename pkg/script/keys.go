@@ -0,0 +1,77 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// keys are the escape sequences of the named keys, as sent by xterm.
+var keys = map[string]string{
+	"enter":     "\r",
+	"return":    "\r",
+	"tab":       "\t",
+	"shift+tab": "\x1b[Z",
+	"backspace": "\x7f",
+	"esc":       "\x1b",
+	"escape":    "\x1b",
+	"space":     " ",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"insert":    "\x1b[2~",
+	"delete":    "\x1b[3~",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+	"f1":        "\x1bOP",
+	"f2":        "\x1bOQ",
+	"f3":        "\x1bOR",
+	"f4":        "\x1bOS",
+	"f5":        "\x1b[15~",
+	"f6":        "\x1b[17~",
+	"f7":        "\x1b[18~",
+	"f8":        "\x1b[19~",
+	"f9":        "\x1b[20~",
+	"f10":       "\x1b[21~",
+	"f11":       "\x1b[23~",
+	"f12":       "\x1b[24~",
+}
+
+// keySequence returns the bytes sent by the key, such as "ctrl+c", "alt+f", "up" or "q".
+func keySequence(name string) ([]byte, error) {
+	lower := strings.ToLower(name)
+	if seq, ok := keys[lower]; ok {
+		return []byte(seq), nil
+	}
+
+	if key, ok := strings.CutPrefix(lower, "ctrl+"); ok {
+		if key == "space" {
+			return []byte{0}, nil
+		}
+		if len(key) == 1 {
+			switch c := key[0]; {
+			case c >= 'a' && c <= 'z', c >= '@' && c <= '_':
+				return []byte{c & 0x1f}, nil
+			case c == '?':
+				return []byte{0x7f}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key: %s", name)
+	}
+
+	if strings.HasPrefix(lower, "alt+") {
+		seq, err := keySequence(name[len("alt+"):])
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{'\x1b'}, seq...), nil
+	}
+
+	if utf8.RuneCountInString(name) == 1 {
+		return []byte(name), nil
+	}
+	return nil, fmt.Errorf("unknown key: %s", name)
+}
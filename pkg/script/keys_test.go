@@ -0,0 +1,48 @@
+package script
+
+import (
+	"testing"
+)
+
+func TestKeySequence(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "q", want: "q"},
+		{name: "Q", want: "Q"},
+		{name: "é", want: "é"},
+		{name: "enter", want: "\r"},
+		{name: "Enter", want: "\r"},
+		{name: "tab", want: "\t"},
+		{name: "shift+tab", want: "\x1b[Z"},
+		{name: "up", want: "\x1b[A"},
+		{name: "f1", want: "\x1bOP"},
+		{name: "f12", want: "\x1b[24~"},
+		{name: "ctrl+c", want: "\x03"},
+		{name: "CTRL+C", want: "\x03"},
+		{name: "ctrl+[", want: "\x1b"},
+		{name: "ctrl+space", want: "\x00"},
+		{name: "ctrl+?", want: "\x7f"},
+		{name: "alt+f", want: "\x1bf"},
+		{name: "alt+F", want: "\x1bF"},
+		{name: "alt+up", want: "\x1b\x1b[A"},
+		{name: "alt+ctrl+x", want: "\x1b\x18"},
+		{name: "ctrl+1", wantErr: true},
+		{name: "ctrl+up", wantErr: true},
+		{name: "alt+nope", wantErr: true},
+		{name: "nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keySequence(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("keySequence(%q) error = %v, wantErr %t", tt.name, err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("keySequence(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
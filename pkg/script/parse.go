@@ -0,0 +1,500 @@
+package script
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+// defaultWaitTimeout is how long @wait-for waits when no timeout is given.
+const defaultWaitTimeout = time.Minute
+
+type parser struct {
+	// vars are set by @set, flagVars by WithVars take precedence over them.
+	vars     map[string]string
+	flagVars map[string]string
+
+	// includes are the files being parsed, from the script to the innermost @include,
+	// names are shown relative to rootDir.
+	includes []string
+	rootDir  string
+
+	ctx    context.Context
+	script *Script
+	hash   hash.Hash
+	errs   Errors
+}
+
+type Option func(*parser)
+
+// WithVars sets the variables expanded in the scripts, @set does not change them.
+func WithVars(vars map[string]string) Option {
+	return func(p *parser) {
+		p.flagVars = vars
+	}
+}
+
+// WithContext stops the parsing when the context is canceled.
+func WithContext(ctx context.Context) Option {
+	return func(p *parser) {
+		p.ctx = ctx
+	}
+}
+
+// ParseFile parses the script in the file, see Parse.
+func ParseFile(file string, options ...Option) (*Script, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(file, filepath.Dir(file), f, options...)
+}
+
+// Parse parses the script, with the included scripts and the variables expanded.
+// The name of the script is used in the errors, and the files named by the script
+// are relative to dir. The error lists every problem found, as Errors.
+func Parse(name, dir string, r io.Reader, options ...Option) (*Script, error) {
+	p := &parser{
+		vars:    map[string]string{},
+		hash:    sha256.New(),
+		ctx:     context.Background(),
+		rootDir: dir,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	p.script = &Script{
+		Name: name,
+		Vars: p.flagVars,
+	}
+	p.includes = append(p.includes, absPath(name))
+	p.parse(name, dir, r)
+	p.script.Hash = hex.EncodeToString(p.hash.Sum(nil))
+	err := p.ctx.Err()
+	if err != nil {
+		return p.script, err
+	}
+	if len(p.errs) != 0 {
+		return p.script, p.errs
+	}
+	return p.script, nil
+}
+
+func (p *parser) fail(pos Pos, err error) {
+	p.errs = append(p.errs, &Error{Pos: pos, Err: err})
+}
+
+func (p *parser) parse(name, dir string, r io.Reader) {
+	reader := bufio.NewReader(r)
+	pos := Pos{File: name}
+	for {
+		if p.ctx.Err() != nil {
+			return
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			p.fail(pos, err)
+			return
+		}
+		if line == "" && err == io.EOF {
+			return
+		}
+		pos.Line++
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		line, expandErr := p.expand(line)
+		if expandErr != nil {
+			p.fail(pos, expandErr)
+		} else if directive, ok := strings.CutPrefix(line, "@"); ok {
+			p.hash.Write([]byte(line + "\n"))
+			p.directive(pos, dir, directive)
+		} else {
+			p.hash.Write([]byte(line + "\n"))
+			p.script.Steps = append(p.script.Steps, Command{Pos: pos, Line: line})
+		}
+
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+func (p *parser) directive(pos Pos, dir, line string) {
+	args, err := shlex.Split(line)
+	if err != nil {
+		p.fail(pos, err)
+		return
+	}
+	if len(args) == 0 {
+		p.fail(pos, fmt.Errorf("missing directive name after @"))
+		return
+	}
+
+	switch args[0] {
+	case "include":
+		if len(args) != 2 {
+			p.fail(pos, fmt.Errorf("include expects 2 arguments, got %d", len(args)))
+			return
+		}
+		p.include(pos, dir, args[1])
+	case "set":
+		if len(args) < 2 {
+			p.fail(pos, fmt.Errorf("set expects at least 2 arguments, got %d", len(args)))
+			return
+		}
+		err = p.setVars(args[1:])
+		if err != nil {
+			p.fail(pos, err)
+		}
+	case "title":
+		if len(args) != 2 {
+			p.fail(pos, fmt.Errorf("title expects 2 arguments, got %d", len(args)))
+			return
+		}
+		p.script.Title = args[1]
+	default:
+		step, err := parseDirective(pos, dir, args)
+		if err != nil {
+			p.fail(pos, err)
+			return
+		}
+		p.script.Steps = append(p.script.Steps, step)
+	}
+}
+
+// include parses the file, relative to the script including it, in place of the directive.
+// A file that is already being parsed is a cycle and is not parsed again.
+func (p *parser) include(pos Pos, dir, file string) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	path := absPath(file)
+	if i := slices.Index(p.includes, path); i >= 0 {
+		var cycle []string
+		for _, included := range p.includes[i:] {
+			cycle = append(cycle, p.displayName(included))
+		}
+		cycle = append(cycle, p.displayName(path))
+		p.fail(pos, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> ")))
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		p.fail(pos, err)
+		return
+	}
+	defer f.Close()
+	p.includes = append(p.includes, path)
+	p.parse(file, filepath.Dir(file), f)
+	p.includes = p.includes[:len(p.includes)-1]
+}
+
+// absPath returns the absolute path of the file, or the file when it has none.
+func absPath(file string) string {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	return path
+}
+
+// displayName returns the path relative to the directory of the script when it is inside it.
+func (p *parser) displayName(path string) string {
+	rel, err := filepath.Rel(absPath(p.rootDir), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+func parseDirective(pos Pos, dir string, args []string) (Step, error) {
+	switch args[0] {
+	case "pause":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("pause expects 1 argument, got %d", len(args))
+		}
+		return Pause{Pos: pos}, nil
+	case "sleep":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("sleep expects 2 arguments, got %d", len(args))
+		}
+		d, err := parseDuration(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Sleep{Pos: pos, Duration: d}, nil
+	case "typing-interval":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("typing-interval expects 2 arguments, got %d", len(args))
+		}
+		interval, err := parseDuration(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Typing{Pos: pos, Interval: &interval}, nil
+	case "typing", "typing-line":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s expects at least 2 arguments, got %d", args[0], len(args))
+		}
+		t := Typing{Pos: pos, Line: args[0] == "typing-line"}
+		err := parseTyping(&t, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case "wait-for":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("wait-for expects 2 or 3 arguments, got %d", len(args))
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		timeout := defaultWaitTimeout
+		if len(args) == 3 {
+			timeout, err = parseDuration(args[2])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return WaitFor{Pos: pos, Regexp: re, Timeout: timeout}, nil
+	case "key":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("key expects 2 or 3 arguments, got %d", len(args))
+		}
+		seq, err := keySequence(args[1])
+		if err != nil {
+			return nil, err
+		}
+		count := 1
+		if len(args) == 3 {
+			count, err = strconv.Atoi(args[2])
+			if err != nil {
+				return nil, err
+			}
+			if count < 1 {
+				return nil, fmt.Errorf("key count must be positive, got %d", count)
+			}
+		}
+		return Key{Pos: pos, Name: args[1], Sequence: seq, Count: count}, nil
+	case "prompt":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("prompt expects 2 arguments, got %d", len(args))
+		}
+		re, err := compilePrompt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Prompt{Pos: pos, Regexp: re}, nil
+	case "expect-exit":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expect-exit expects 2 arguments, got %d", len(args))
+		}
+		status, err := parseExitStatus(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return ExpectExit{Pos: pos, Status: status}, nil
+	case "spawn":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("spawn expects at least 2 arguments, got %d", len(args))
+		}
+		s := Spawn{Pos: pos, Args: args[1:]}
+		if s.Args[0] == "--prompt" {
+			if len(s.Args) < 2 {
+				return nil, fmt.Errorf("spawn --prompt expects a regex")
+			}
+			re, err := compilePrompt(s.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			s.Prompt = re
+			s.Args = s.Args[2:]
+		}
+		if len(s.Args) == 0 {
+			return nil, fmt.Errorf("spawn expects a program")
+		}
+		return s, nil
+	case "hide":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("hide expects 1 argument, got %d", len(args))
+		}
+		return Hide{Pos: pos}, nil
+	case "show":
+		if len(args) != 1 && (len(args) != 2 || args[1] != "clear") {
+			return nil, fmt.Errorf("show expects no argument or clear")
+		}
+		return Show{Pos: pos, Clear: len(args) == 2}, nil
+	case "expect":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expect expects 2 arguments, got %d", len(args))
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return Expect{Pos: pos, Regexp: re}, nil
+	case "expect-screen":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expect-screen expects 2 arguments, got %d", len(args))
+		}
+		file := args[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return ExpectScreen{Pos: pos, File: file}, nil
+	}
+	return nil, fmt.Errorf("unknown directive: @%s", args[0])
+}
+
+// parseTyping sets the key=value arguments of a typing directive.
+func parseTyping(t *Typing, args []string) error {
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("typing expects key=value, got %q", arg)
+		}
+		switch key {
+		case "wpm":
+			wpm, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			if wpm <= 0 {
+				return fmt.Errorf("typing wpm must be positive, got %s", value)
+			}
+			// A word is five characters.
+			interval := time.Duration(float64(time.Minute) / (wpm * 5))
+			t.Interval = &interval
+		case "interval":
+			interval, err := parseDuration(value)
+			if err != nil {
+				return err
+			}
+			t.Interval = &interval
+		case "jitter":
+			jitter, err := parseFraction(value)
+			if err != nil {
+				return err
+			}
+			t.Jitter = &jitter
+		case "pause":
+			pause, err := parseDuration(value)
+			if err != nil {
+				return err
+			}
+			t.Pause = &pause
+		case "typos":
+			typos, err := parseFraction(value)
+			if err != nil {
+				return err
+			}
+			t.Typos = &typos
+		case "seed":
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			t.Seed = &seed
+		default:
+			return fmt.Errorf("unknown typing setting: %s", key)
+		}
+	}
+	return nil
+}
+
+// parseDuration parses a number of seconds or a duration such as "1m30s".
+func parseDuration(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func parseFraction(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("expected a number between 0 and 1, got %s", s)
+	}
+	return f, nil
+}
+
+// parseExitStatus parses an exit status, or "any".
+func parseExitStatus(s string) (int, error) {
+	if s == "any" {
+		return AnyExit, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil || status < 0 {
+		return 0, fmt.Errorf("expected an exit status or any, got %q", s)
+	}
+	return status, nil
+}
+
+func compilePrompt(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt regex: %w", err)
+	}
+	return re, nil
+}
+
+var (
+	varName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	varReference = regexp.MustCompile(`\$\{\{\s*([^}\s]*)\s*\}\}`)
+)
+
+// setVars sets the NAME=value variables, the ones given by WithVars are kept.
+func (p *parser) setVars(args []string) error {
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || !varName.MatchString(name) {
+			return fmt.Errorf("set expects NAME=value, got %q", arg)
+		}
+		if _, ok := p.flagVars[name]; ok {
+			continue
+		}
+		p.vars[name] = value
+	}
+	return nil
+}
+
+// expand replaces the ${{NAME}} in the line by the value of the variable.
+func (p *parser) expand(line string) (string, error) {
+	var err error
+	expanded := varReference.ReplaceAllStringFunc(line, func(ref string) string {
+		name := varReference.FindStringSubmatch(ref)[1]
+		if value, ok := p.flagVars[name]; ok {
+			return value
+		}
+		if value, ok := p.vars[name]; ok {
+			return value
+		}
+		if err == nil {
+			err = fmt.Errorf("undefined variable %q", name)
+		}
+		return ref
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
@@ -0,0 +1,441 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// describe returns the step as a string, to compare the steps holding regexps and pointers.
+func describe(step Step) string {
+	line := step.Position().Line
+	switch s := step.(type) {
+	case Command:
+		return fmt.Sprintf("%d command %q", line, s.Line)
+	case Sleep:
+		return fmt.Sprintf("%d sleep %s", line, s.Duration)
+	case Typing:
+		fields := []string{fmt.Sprintf("line=%t", s.Line)}
+		if s.Interval != nil {
+			fields = append(fields, fmt.Sprintf("interval=%s", *s.Interval))
+		}
+		if s.Jitter != nil {
+			fields = append(fields, fmt.Sprintf("jitter=%g", *s.Jitter))
+		}
+		if s.Pause != nil {
+			fields = append(fields, fmt.Sprintf("pause=%s", *s.Pause))
+		}
+		if s.Typos != nil {
+			fields = append(fields, fmt.Sprintf("typos=%g", *s.Typos))
+		}
+		if s.Seed != nil {
+			fields = append(fields, fmt.Sprintf("seed=%d", *s.Seed))
+		}
+		return fmt.Sprintf("%d typing %s", line, strings.Join(fields, " "))
+	case WaitFor:
+		return fmt.Sprintf("%d wait-for %q %s", line, s.Regexp, s.Timeout)
+	case Key:
+		return fmt.Sprintf("%d key %s %q %d", line, s.Name, s.Sequence, s.Count)
+	case Prompt:
+		return fmt.Sprintf("%d prompt %q", line, s.Regexp)
+	case ExpectExit:
+		return fmt.Sprintf("%d expect-exit %d", line, s.Status)
+	case Spawn:
+		prompt := ""
+		if s.Prompt != nil {
+			prompt = s.Prompt.String()
+		}
+		return fmt.Sprintf("%d spawn %q %q", line, prompt, s.Args)
+	case Show:
+		return fmt.Sprintf("%d show clear=%t", line, s.Clear)
+	case Expect:
+		return fmt.Sprintf("%d expect %q", line, s.Regexp)
+	case ExpectScreen:
+		return fmt.Sprintf("%d expect-screen %s", line, filepath.ToSlash(s.File))
+	}
+	return fmt.Sprintf("%d %T", line, step)
+}
+
+func describeAll(steps []Step) string {
+	lines := make([]string, 0, len(steps))
+	for _, step := range steps {
+		lines = append(lines, describe(step))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		vars  map[string]string
+		title string
+		want  []string
+	}{
+		{
+			name:  "commands",
+			input: "echo a\necho \\\nb\n",
+			want: []string{
+				`1 command "echo a"`,
+				`2 command "echo \\"`,
+				`3 command "b"`,
+			},
+		},
+		{
+			name:  "crlf without the last newline",
+			input: "echo a\r\necho b",
+			want: []string{
+				`1 command "echo a"`,
+				`2 command "echo b"`,
+			},
+		},
+		{
+			name:  "timing",
+			input: "@pause\n@sleep 1.5\n@sleep 2m\n",
+			want: []string{
+				`1 script.Pause`,
+				`2 sleep 1.5s`,
+				`3 sleep 2m0s`,
+			},
+		},
+		{
+			name:  "typing",
+			input: "@typing-interval 0.1\n@typing wpm=60 jitter=0.5 seed=1\n@typing-line pause=1s typos=0.1\n",
+			want: []string{
+				`1 typing line=false interval=100ms`,
+				`2 typing line=false interval=200ms jitter=0.5 seed=1`,
+				`3 typing line=true pause=1s typos=0.1`,
+			},
+		},
+		{
+			name:  "running command",
+			input: "top\n@wait-for 'load average'\n@key q\n@key ctrl+c 2\n@wait-for done 5s\n",
+			want: []string{
+				`1 command "top"`,
+				`2 wait-for "load average" 1m0s`,
+				`3 key q "q" 1`,
+				`4 key ctrl+c "\x03" 2`,
+				`5 wait-for "done" 5s`,
+			},
+		},
+		{
+			name:  "prompts and exits",
+			input: "@prompt '>>> $'\n@expect-exit 1\n@expect-exit any\n@spawn python3 -q\n@spawn --prompt '^> ' node\n",
+			want: []string{
+				`1 prompt ">>> $"`,
+				`2 expect-exit 1`,
+				`3 expect-exit -1`,
+				`4 spawn "" ["python3" "-q"]`,
+				`5 spawn "^> " ["node"]`,
+			},
+		},
+		{
+			name:  "hide and expect",
+			input: "@hide\n@show\n@show clear\n@expect '^ok$'\n@expect-screen screen.txt\n",
+			want: []string{
+				`1 script.Hide`,
+				`2 show clear=false`,
+				`3 show clear=true`,
+				`4 expect "^ok$"`,
+				`5 expect-screen dir/screen.txt`,
+			},
+		},
+		{
+			name:  "variables",
+			input: "@set NAME=world GREETING=hello\necho ${{GREETING}} ${{ NAME }}\n",
+			want: []string{
+				`2 command "echo hello world"`,
+			},
+		},
+		{
+			name:  "flag variables take precedence",
+			input: "@set NAME=world\necho ${{NAME}}\n",
+			vars:  map[string]string{"NAME": "flag"},
+			want: []string{
+				`2 command "echo flag"`,
+			},
+		},
+		{
+			name:  "variables in directives",
+			input: "@set WAIT=2\n@sleep ${{WAIT}}\n",
+			want: []string{
+				`2 sleep 2s`,
+			},
+		},
+		{
+			name:  "shell variables are left",
+			input: "echo ${HOME} $USER\n",
+			want: []string{
+				`1 command "echo ${HOME} $USER"`,
+			},
+		},
+		{
+			name:  "title",
+			input: "@title 'A demo'\necho a\n",
+			title: "A demo",
+			want: []string{
+				`2 command "echo a"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse("test.demo", "dir", strings.NewReader(tt.input), WithVars(tt.vars))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if s.Title != tt.title {
+				t.Errorf("Title = %q, want %q", s.Title, tt.title)
+			}
+			got := describeAll(s.Steps)
+			want := strings.Join(tt.want, "\n")
+			if got != want {
+				t.Errorf("Steps =\n%s\nwant\n%s", got, want)
+			}
+			for _, step := range s.Steps {
+				if file := step.Position().File; file != "test.demo" {
+					t.Errorf("File of %s = %q, want %q", describe(step), file, "test.demo")
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "bare @",
+			input: "echo a\n@\n",
+			want:  []string{"test.demo:2: missing directive name after @"},
+		},
+		{
+			name:  "unknown directive",
+			input: "@nope\n",
+			want:  []string{"test.demo:1: unknown directive: @nope"},
+		},
+		{
+			name:  "unterminated quote",
+			input: "@expect 'abc\n",
+			want:  []string{"test.demo:1: "},
+		},
+		{
+			name:  "argument counts",
+			input: "@pause now\n@sleep\n@wait-for\n@key\n@prompt\n@expect-exit\n@spawn\n@hide now\n@show now\n@expect\n@expect-screen\n@include\n@set\n@title\n",
+			want: []string{
+				"test.demo:1: pause expects 1 argument, got 2",
+				"test.demo:2: sleep expects 2 arguments, got 1",
+				"test.demo:3: wait-for expects 2 or 3 arguments, got 1",
+				"test.demo:4: key expects 2 or 3 arguments, got 1",
+				"test.demo:5: prompt expects 2 arguments, got 1",
+				"test.demo:6: expect-exit expects 2 arguments, got 1",
+				"test.demo:7: spawn expects at least 2 arguments, got 1",
+				"test.demo:8: hide expects 1 argument, got 2",
+				"test.demo:9: show expects no argument or clear",
+				"test.demo:10: expect expects 2 arguments, got 1",
+				"test.demo:11: expect-screen expects 2 arguments, got 1",
+				"test.demo:12: include expects 2 arguments, got 1",
+				"test.demo:13: set expects at least 2 arguments, got 1",
+				"test.demo:14: title expects 2 arguments, got 1",
+			},
+		},
+		{
+			name:  "invalid values",
+			input: "@sleep soon\n@wait-for '('\n@key ctrl+1\n@key q 0\n@prompt '['\n@expect-exit -2\n@spawn --prompt\n@spawn --prompt '>'\n",
+			want: []string{
+				"test.demo:1: ",
+				"test.demo:2: ",
+				"test.demo:3: unknown key: ctrl+1",
+				"test.demo:4: key count must be positive, got 0",
+				"test.demo:5: invalid prompt regex: ",
+				"test.demo:6: expected an exit status or any, got \"-2\"",
+				"test.demo:7: spawn --prompt expects a regex",
+				"test.demo:8: spawn expects a program",
+			},
+		},
+		{
+			name:  "typing settings",
+			input: "@typing wpm\n@typing wpm=0\n@typing jitter=2\n@typing speed=1\n",
+			want: []string{
+				"test.demo:1: typing expects key=value, got \"wpm\"",
+				"test.demo:2: typing wpm must be positive, got 0",
+				"test.demo:3: expected a number between 0 and 1, got 2",
+				"test.demo:4: unknown typing setting: speed",
+			},
+		},
+		{
+			name:  "variables",
+			input: "@set 1X=a\necho ${{MISSING}}\n",
+			want: []string{
+				"test.demo:1: set expects NAME=value, got \"1X=a\"",
+				"test.demo:2: undefined variable \"MISSING\"",
+			},
+		},
+		{
+			name:  "missing include",
+			input: "@include missing.demo\n",
+			want:  []string{"test.demo:1: open "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("test.demo", t.TempDir(), strings.NewReader(tt.input))
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Parse() error = %v, want Errors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("Parse() errors =\n%v\nwant %d errors", err, len(tt.want))
+			}
+			for i, e := range errs {
+				if !strings.HasPrefix(e.Error(), tt.want[i]) {
+					t.Errorf("error %d = %q, want prefix %q", i, e.Error(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseFileInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.demo":          "@set NAME=main\n@include lib/setup.demo\necho ${{NAME}} ${{LIB}}\n",
+		"lib/setup.demo":     "@set LIB=lib\n@include common.demo\n@expect-screen screen.txt\n",
+		"lib/common.demo":    "echo common\n",
+		"lib/screen.txt":     "",
+		"other/missing.demo": "@include nothing.demo\n",
+	})
+
+	s, err := ParseFile(filepath.Join(dir, "main.demo"))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	want := []struct {
+		file string
+		desc string
+	}{
+		{"lib/common.demo", `1 command "echo common"`},
+		{"lib/setup.demo", "3 expect-screen " + filepath.ToSlash(filepath.Join(dir, "lib/screen.txt"))},
+		{"main.demo", `3 command "echo main lib"`},
+	}
+	if len(s.Steps) != len(want) {
+		t.Fatalf("Steps =\n%s\nwant %d steps", describeAll(s.Steps), len(want))
+	}
+	for i, step := range s.Steps {
+		if got := describe(step); got != want[i].desc {
+			t.Errorf("step %d = %s, want %s", i, got, want[i].desc)
+		}
+		if got := step.Position().File; got != filepath.Join(dir, want[i].file) {
+			t.Errorf("File of step %d = %s, want %s", i, got, filepath.Join(dir, want[i].file))
+		}
+	}
+
+	_, err = ParseFile(filepath.Join(dir, "other/missing.demo"))
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "other/missing.demo")+":1: open ") {
+		t.Errorf("ParseFile() error = %v, want the position of the include", err)
+	}
+}
+
+func TestParseFileIncludeCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "self",
+			files: map[string]string{
+				"main.demo": "echo a\n@include main.demo\n@include main.demo\n",
+			},
+			want: []string{
+				"main.demo:2: include cycle: main.demo -> main.demo",
+				"main.demo:3: include cycle: main.demo -> main.demo",
+			},
+		},
+		{
+			name: "mutual",
+			files: map[string]string{
+				"main.demo": "@include a.demo\n",
+				"a.demo":    "@include b.demo\n@include b.demo\n",
+				"b.demo":    "echo b\n@include a.demo\n",
+			},
+			want: []string{
+				"b.demo:2: include cycle: a.demo -> b.demo -> a.demo",
+				"b.demo:2: include cycle: a.demo -> b.demo -> a.demo",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, err := ParseFile(filepath.Join(dir, "main.demo"))
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("ParseFile() error = %v, want Errors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("ParseFile() error = %v, want %d errors", err, len(tt.want))
+			}
+			for i, e := range errs {
+				want := filepath.Join(dir, tt.want[i])
+				if e.Error() != want {
+					t.Errorf("error %d = %v, want %v", i, e, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Parse("test.demo", "", strings.NewReader("echo a\n"), WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Parse() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	parse := func(input string, vars map[string]string) string {
+		t.Helper()
+		s, err := Parse("test.demo", "", strings.NewReader(input), WithVars(vars))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Hash
+	}
+
+	a := parse("echo ${{NAME}}\n", map[string]string{"NAME": "a"})
+	if b := parse("echo ${{NAME}}\n", map[string]string{"NAME": "a"}); a != b {
+		t.Errorf("Hash of the same script = %s and %s, want equal", a, b)
+	}
+	if b := parse("echo a\n", nil); a != b {
+		t.Errorf("Hash of the expanded script = %s and %s, want equal", a, b)
+	}
+	if b := parse("echo ${{NAME}}\n", map[string]string{"NAME": "b"}); a == b {
+		t.Errorf("Hash with other variables = %s, want another hash", b)
+	}
+}
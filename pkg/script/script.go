@@ -0,0 +1,185 @@
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Script is a parsed script, with the included scripts.
+type Script struct {
+	// Name is the name of the script, the path of its file,
+	// empty when the script did not come from a file.
+	Name string
+	// Hash is the sha256 of the lines of the scripts, with the variables expanded,
+	// so it changes with the included scripts and the variables.
+	Hash string
+	// Title is set by @title.
+	Title string
+	// Vars are the variables given to the parser.
+	Vars map[string]string
+
+	Steps []Step
+}
+
+// Pos is the file and the line of a step.
+type Pos struct {
+	File string
+	Line int
+}
+
+func (p Pos) Position() Pos {
+	return p
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Step is a line of a script, a command typed into the shell or a directive.
+type Step interface {
+	Position() Pos
+}
+
+// Command is a line typed into the shell,
+// a line ending with a backslash is continued by the next command.
+type Command struct {
+	Pos
+	Line string
+}
+
+// Continued reports whether the next command continues the line.
+func (c Command) Continued() bool {
+	return strings.HasSuffix(c.Line, "\\")
+}
+
+// Pause waits for a key press.
+type Pause struct {
+	Pos
+}
+
+// Sleep waits before the next step.
+type Sleep struct {
+	Pos
+	Duration time.Duration
+}
+
+// Typing changes how the commands are typed, only the set fields are changed.
+type Typing struct {
+	Pos
+	// Line changes the typing of the next command only.
+	Line bool
+
+	Interval *time.Duration
+	Jitter   *float64
+	Pause    *time.Duration
+	Typos    *float64
+	Seed     *int64
+}
+
+// WaitFor waits until the output of the last command matches.
+type WaitFor struct {
+	Pos
+	Regexp  *regexp.Regexp
+	Timeout time.Duration
+}
+
+// Key presses a key Count times.
+type Key struct {
+	Pos
+	Name     string
+	Sequence []byte
+	Count    int
+}
+
+// Prompt sets the prompt from here on.
+type Prompt struct {
+	Pos
+	Regexp *regexp.Regexp
+}
+
+// ExpectExit allows the next command to exit with the status, or any when it is AnyExit.
+type ExpectExit struct {
+	Pos
+	Status int
+}
+
+// AnyExit is the status of ExpectExit allowing any exit status.
+const AnyExit = -1
+
+// Spawn types the command of a program, and types the next commands into it
+// until it exits. The prompt of the program is guessed when Prompt is nil.
+type Spawn struct {
+	Pos
+	Prompt *regexp.Regexp
+	Args   []string
+}
+
+// Hide stops recording the output.
+type Hide struct {
+	Pos
+}
+
+// Show records the output again, on a clear screen when Clear is set.
+type Show struct {
+	Pos
+	Clear bool
+}
+
+// Expect checks the output of the last command.
+type Expect struct {
+	Pos
+	Regexp *regexp.Regexp
+}
+
+// ExpectScreen checks the screen against the file.
+type ExpectScreen struct {
+	Pos
+	File string
+}
+
+// ActsOnCommand reports whether the step is a directive for the running command,
+// which does not wait until the command finishes.
+func ActsOnCommand(step Step) bool {
+	switch step.(type) {
+	case Key, WaitFor, Prompt:
+		return true
+	}
+	return false
+}
+
+// IsAssertion reports whether the step checks the recording,
+// which fails when the step is never reached.
+func IsAssertion(step Step) bool {
+	switch step.(type) {
+	case Expect, ExpectScreen, ExpectExit:
+		return true
+	}
+	return false
+}
+
+// Error is a problem at a line of a script.
+type Error struct {
+	Pos
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors are all the problems found in the scripts.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
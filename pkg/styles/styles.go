@@ -1,8 +1,11 @@
 package styles
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/wzshiming/democtl/pkg/cast"
 	"github.com/wzshiming/vt10x"
 	"gopkg.in/yaml.v3"
 )
@@ -47,6 +50,55 @@ func NewStylesFromFile(path string) (*Styles, error) {
 	return c, nil
 }
 
+// Resolve returns the styles of the profile, or of the theme of the header
+// when no profile is given, or the default styles.
+func Resolve(profile string, header cast.Header) (*Styles, error) {
+	if profile != "" {
+		return NewStylesFromFile(profile)
+	}
+	if header.Theme != nil {
+		return NewStylesFromTheme(header.Theme.Fg, header.Theme.Bg, header.Theme.Palette)
+	}
+	return Default(), nil
+}
+
+// NewStylesFromTheme returns the styles of an asciicast theme,
+// palette is a colon separated list of 8 or 16 colors.
+func NewStylesFromTheme(fg, bg, palette string) (*Styles, error) {
+	p := strings.Split(palette, ":")
+	switch len(p) {
+	case 8:
+		// The bright colors are the same as the normal ones.
+		p = append(p, p...)
+	case 16:
+	default:
+		return nil, fmt.Errorf("wrong palette length (%d): expected 8 or 16 colors", len(p))
+	}
+
+	return &Styles{
+		Color0:  p[0],
+		Color1:  p[1],
+		Color2:  p[2],
+		Color3:  p[3],
+		Color4:  p[4],
+		Color5:  p[5],
+		Color6:  p[6],
+		Color7:  p[7],
+		Color8:  p[8],
+		Color9:  p[9],
+		Color10: p[10],
+		Color11: p[11],
+		Color12: p[12],
+		Color13: p[13],
+		Color14: p[14],
+		Color15: p[15],
+
+		Foreground:  fg,
+		Background:  bg,
+		CursorColor: fg,
+	}, nil
+}
+
 func (s Styles) GetColorForHex(i vt10x.Color) string {
 	switch i {
 	case vt10x.DefaultBG:
@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"regexp"
+)
+
+// ansiRegexp matches the escape sequences and the carriage returns written to terminals.
+var ansiRegexp = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]|\r`)
+
+// StripANSI returns str without the escape sequences, leaving only the text.
+func StripANSI(str string) string {
+	return ansiRegexp.ReplaceAllString(str, "")
+}
@@ -32,3 +32,21 @@ func StrLen(str string) int {
 	}
 	return i
 }
+
+// Truncate shortens str to fit the width, marking the cut with an ellipsis.
+func Truncate(str string, width int) string {
+	if StrLen(str) <= width {
+		return str
+	}
+	out := []rune{}
+	i := 0
+	for _, v := range str {
+		w := runeWidth(v)
+		if i+w > width-1 {
+			break
+		}
+		i += w
+		out = append(out, v)
+	}
+	return string(out) + "…"
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// devel is the version of a development build whose revision and executable are unknown.
+const devel = "(devel)"
+
+// Version returns the version of democtl, with the revision of a development build,
+// or the hash of the executable when the revision is unknown, such as with go run.
+func Version() string {
+	return versionOnce()
+}
+
+var versionOnce = sync.OnceValue(version)
+
+// IsKnownVersion reports whether the version tells the build of democtl apart,
+// two recordings with the same unknown version may come from different builds.
+func IsKnownVersion(version string) bool {
+	return version != "" && version != "unknown" && version != devel
+}
+
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	if version != "" && version != devel {
+		return version
+	}
+
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		hash, err := executableHash()
+		if err != nil {
+			return devel
+		}
+		return devel + "+" + hash
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return devel + "-" + revision
+}
+
+// executableHash returns the start of the sha256 of the running executable.
+func executableHash() (string, error) {
+	name, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}